@@ -9,4 +9,14 @@ const (
 	JsonRpcTransactionError = -32001
 	JsonRpcAuthError        = -32002
 	JsonRpcClientError      = -32003
+
+	// ERC-4337 user operation rejections
+	JsonRpcRejectedByEntryPointOrAccount = -32500
+	JsonRpcRejectedByPaymaster           = -32501
+	JsonRpcBannedOpcode                  = -32502
+	JsonRpcBannedStorageAccess           = -32502
+	JsonRpcOutOfTimeRange                = -32503
+	JsonRpcEntityBannedOrThrottled       = -32504
+	JsonRpcInsufficientStake             = -32505
+	JsonRpcInvalidSignature              = -32507
 )
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// accountingEntry is one line of the accounting log.
+type accountingEntry struct {
+	Time   time.Time      `json:"time"`
+	Kind   string         `json:"kind"`
+	From   common.Address `json:"from"`
+	To     common.Address `json:"to"`
+	Amount *big.Int       `json:"amount"`
+	TxHash common.Hash    `json:"txHash"`
+}
+
+const (
+	accountingSweepToSigner     = "sweep_to_signer"
+	accountingSweepToColdWallet = "sweep_to_cold_wallet"
+)
+
+var accountingMu sync.Mutex
+
+// recordAccounting appends entry as a JSON line to the accounting log at path.
+func recordAccounting(path string, entry accountingEntry) {
+	entry.Time = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error("failed to encode accounting entry", "error", err)
+		return
+	}
+
+	accountingMu.Lock()
+	defer accountingMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Error("failed to open accounting log", "path", path, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Error("failed to write accounting log", "path", path, "error", err)
+	}
+}
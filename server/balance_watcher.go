@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// balanceWatcher checks the signer balance on every new block, pauses and
+// resumes bundling and sweeps earnings.
+type balanceWatcher struct {
+	b        *bundler
+	conn     *ethclient.Client
+	settings *chainSettings
+	signer   common.Address
+
+	// toSigner sweeps the beneficiary's earnings back to the signer while the
+	// signer runs low. It is nil when the beneficiary is the signer or when we
+	// don't hold the beneficiary key.
+	toSigner *sweeper
+	// toColdWallet periodically moves the beneficiary balance above
+	// SweepFloat to ColdWallet. It is nil when sweeping is disabled.
+	toColdWallet  *sweeper
+	lastColdSweep time.Time
+
+	heads <-chan *types.Header
+
+	balanceGauge metrics.Gauge // signer balance in gwei
+	lowGauge     metrics.Gauge // 1 while below the soft threshold
+	pausedGauge  metrics.Gauge // 1 while bundling is paused
+}
+
+func newBalanceWatcher(b *bundler) (*balanceWatcher, error) {
+	conn, settings, signer := b.nodes.Eth(), b.settings, b.signer
+	w := &balanceWatcher{
+		b:            b,
+		conn:         conn,
+		settings:     settings,
+		signer:       signer.From,
+		heads:        b.heads.newHeads(),
+		balanceGauge: metrics.NewRegisteredGauge(b.metricsPrefix()+"signer/balance", nil),
+		lowGauge:     metrics.NewRegisteredGauge(b.metricsPrefix()+"signer/low", nil),
+		pausedGauge:  metrics.NewRegisteredGauge(b.metricsPrefix()+"paused", nil),
+	}
+
+	beneficiary := settings.beneficiary(signer.From)
+	beneficiaryKey, beneficiaryNonces := signer, b.nonces
+	if beneficiary != signer.From {
+		beneficiaryKey = nil
+		if settings.BeneficiaryKeyIn != "" {
+			key, err := newTransactor(conn, settings.BeneficiaryKeyIn, settings.BeneficiaryPassphrase)
+			if err != nil {
+				return nil, err
+			}
+			if key.From == beneficiary {
+				beneficiaryKey, beneficiaryNonces = key, newAccountNonces(conn, key.From)
+			} else {
+				log.Warn("BENEFICIARY_KEY_IN does not unlock the beneficiary, not sweeping earnings", "key", key.From, "beneficiary", beneficiary)
+			}
+		}
+		if beneficiaryKey != nil {
+			w.toSigner = &sweeper{conn: conn, key: beneficiaryKey, nonces: beneficiaryNonces, to: signer.From, kind: accountingSweepToSigner, accountingLog: settings.AccountingLog}
+		}
+	}
+	if settings.ColdWallet != zeroAddress && settings.SweepInterval > 0 {
+		if beneficiaryKey == nil {
+			log.Warn("no key for the beneficiary, not sweeping to the cold wallet", "beneficiary", beneficiary)
+		} else {
+			w.toColdWallet = &sweeper{conn: conn, key: beneficiaryKey, nonces: beneficiaryNonces, to: settings.ColdWallet, kind: accountingSweepToColdWallet, accountingLog: settings.AccountingLog}
+		}
+	}
+	return w, nil
+}
+
+// run handles every new head of the chain until the process exits.
+func (w *balanceWatcher) run() {
+	for head := range w.heads {
+		w.onBlock(head.Number)
+	}
+}
+
+func (w *balanceWatcher) onBlock(number *big.Int) {
+	balance, err := w.conn.BalanceAt(context.Background(), w.signer, number)
+	if err != nil {
+		log.Error("failed to get signer balance", "signer", w.signer, "error", err)
+		return
+	}
+	w.balanceGauge.Update(new(big.Int).Div(balance, big.NewInt(params.GWei)).Int64())
+
+	if balance.Cmp(w.settings.BalanceSoftThreshold) < 0 {
+		w.lowGauge.Update(1)
+		log.Warn("signer balance below soft threshold", "signer", w.signer, "balance", balance, "threshold", w.settings.BalanceSoftThreshold)
+		if w.toSigner != nil {
+			w.toSigner.sweep(common.Big0)
+		}
+	} else {
+		w.lowGauge.Update(0)
+	}
+	if w.toColdWallet != nil && time.Since(w.lastColdSweep) >= w.settings.SweepInterval {
+		w.lastColdSweep = time.Now()
+		w.toColdWallet.sweep(w.settings.SweepFloat)
+	}
+
+	if balance.Cmp(w.settings.BalanceHardThreshold) < 0 {
+		if atomic.CompareAndSwapInt32(&w.b.paused, 0, 1) {
+			w.pausedGauge.Update(1)
+			log.Error("signer balance below hard threshold, pausing bundling", "signer", w.signer, "balance", balance, "threshold", w.settings.BalanceHardThreshold)
+		}
+		return
+	}
+	if atomic.CompareAndSwapInt32(&w.b.paused, 1, 0) {
+		w.pausedGauge.Update(0)
+		log.Info("signer balance restored, resuming bundling", "signer", w.signer, "balance", balance)
+	}
+}
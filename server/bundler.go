@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// bundler bundles for one chain. Everything it holds, from its nodes to its
+// mempool and entity reputation, belongs to that chain only.
+type bundler struct {
+	settings *chainSettings
+	nodes    *clientManager
+	chain    *chainProfile
+	heads    *headCache
+	fees     *feeOracle
+	signer   *bind.TransactOpts
+	// nonces sends every transaction of the signer, bundles and sweeps alike.
+	nonces *accountNonces
+
+	pool       *mempool
+	userOps    *userOpStore
+	reputation *reputationManager
+	simCache   *simulationCache
+	stateCache *nodeStateCache
+
+	// paused is set to 1 while the signer balance is below the hard threshold.
+	// Ops keep being accepted into the mempool but are not submitted.
+	paused  int32
+	watcher *balanceWatcher
+	// lastBundle is when the last bundle was submitted.
+	lastBundle time.Time
+}
+
+// newBundler connects to the nodes of settings and loads the chain's profile,
+// signer and reputation.
+func newBundler(settings *chainSettings) (*bundler, error) {
+	nodes, err := newClientManager(settings.NodeURLs, conf.NodeMaxLag, conf.NodeHealthInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the nodes: %w", err)
+	}
+	chain, err := loadChainProfile(nodes.Eth(), settings)
+	if err != nil {
+		return nil, err
+	}
+	if chain.EntryPointVersions, err = detectEntryPointVersions(nodes.Eth(), chain.EntryPoints, settings); err != nil {
+		return nil, err
+	}
+	signer, err := newTransactor(nodes.Eth(), settings.KeyIn, settings.Passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signer: %w", err)
+	}
+	reputation, err := loadReputation(settings.ReputationFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reputation: %w", err)
+	}
+	b := &bundler{
+		settings:   settings,
+		nodes:      nodes,
+		chain:      chain,
+		heads:      newHeadCache(nodes.Eth(), chain, settings.BlockPollInterval),
+		signer:     signer,
+		nonces:     newAccountNonces(nodes.Eth(), signer.From),
+		pool:       &mempool{},
+		userOps:    newUserOpStore(),
+		reputation: reputation,
+		stateCache: &nodeStateCache{},
+	}
+	b.fees = newFeeOracle(nodes.Eth(), b.heads, chain, settings)
+	if b.simCache, err = newSimulationCache(int(conf.SimCacheSize), b.metricsPrefix(), b.heads, b.simulateAndTrace); err != nil {
+		return nil, err
+	}
+	if b.watcher, err = newBalanceWatcher(b); err != nil {
+		return nil, fmt.Errorf("failed to start balance watcher: %w", err)
+	}
+	return b, nil
+}
+
+// run starts the health checks, head following, reputation upkeep and
+// bundling loop.
+func (b *bundler) run() {
+	go b.nodes.run()
+	go b.heads.run()
+	go b.reputation.run()
+	go b.watcher.run()
+	go b.bundleLoop(b.heads.newHeads())
+}
+
+// routes registers the RPC handlers under prefix.
+func (b *bundler) routes(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc(prefix+"/eth_sendUserOperation", b.handle_eth_sendUserOperation)
+	mux.HandleFunc(prefix+"/eth_supportedEntryPoints", b.handle_eth_supportedEntryPoints)
+	mux.HandleFunc(prefix+"/eth_getUserOperationByHash", b.handle_eth_getUserOperationByHash)
+	mux.HandleFunc(prefix+"/eth_estimateUserOperationGas", b.handle_eth_estimateUserOperationGas)
+	mux.HandleFunc(prefix+"/bundler_getSenderAddress", b.handle_bundler_getSenderAddress)
+	mux.HandleFunc(prefix+"/bundler_getUserOperationGasPrice", b.handle_bundler_getUserOperationGasPrice)
+}
+
+// rpcPrefix is the path the chain's handlers are served under.
+func (b *bundler) rpcPrefix() string {
+	return fmt.Sprintf("/rpc/%d", b.chain.ChainID)
+}
+
+// metricsPrefix namespaces the chain's metrics when several chains are run.
+func (b *bundler) metricsPrefix() string {
+	if b.settings.Name == "" {
+		return "bundler/"
+	}
+	return "bundler/" + b.settings.Name + "/"
+}
+
+func (b *bundler) isBundlingPaused() bool {
+	return atomic.LoadInt32(&b.paused) == 1
+}
+
+// userOpHash returns the hash the EntryPoint ep identifies op by. v0.7 hashes
+// are computed locally, v0.6 EntryPoints are asked for theirs.
+func (b *bundler) userOpHash(ep common.Address, op _UserOperation) (common.Hash, error) {
+	if b.chain.version(ep) == entryPointVersion07 {
+		return userOpHashV07(op, ep, b.chain.ChainID)
+	}
+	return getUserOpHash(b.nodes.Eth(), ep, op)
+}
+
+// checkOpVersion rejects ops that the version of the EntryPoint ep cannot
+// take.
+func (b *bundler) checkOpVersion(ep common.Address, op _UserOperation) error {
+	return checkOpFields(b.chain.version(ep), op)
+}
+
+// simulateAndTrace simulates op against the EntryPoint ep with the configured
+// simulator.
+func (b *bundler) simulateAndTrace(client *rpc.Client, ep common.Address, op _UserOperation) (*simulationResult, *validationTrace, error) {
+	return simulateAndTrace(b.chain.Config, ep, b.chain.version(ep), b.heads, b.stateCache, client, op)
+}
+
+// startBundlers creates a bundler for every configured chain, starts them and
+// serves their RPC handlers on mux. Every chain is served under
+// /rpc/{chainId}; a single chain is also served at the root.
+func startBundlers(mux *http.ServeMux) ([]*bundler, error) {
+	var bundlers []*bundler
+	chainIDs := map[uint64]string{}
+	for _, settings := range conf.Chains {
+		b, err := newBundler(settings)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: %w", settings.Name, err)
+		}
+		if other, ok := chainIDs[b.chain.ChainID]; ok {
+			return nil, fmt.Errorf("chains %s and %s are both chain %d", other, settings.Name, b.chain.ChainID)
+		}
+		chainIDs[b.chain.ChainID] = settings.Name
+		log.Info("loaded chain", "name", settings.Name, "network", b.chain.Name, "chainId", b.chain.ChainID,
+			"entryPoints", b.chain.EntryPoints, "entryPointVersions", b.chain.EntryPointVersions, "signer", b.signer.From, "l2", b.chain.L2)
+		b.routes(mux, b.rpcPrefix())
+		bundlers = append(bundlers, b)
+	}
+	if len(bundlers) == 1 {
+		bundlers[0].routes(mux, "")
+	}
+	for _, b := range bundlers {
+		b.run()
+	}
+	return bundlers, nil
+}
+
+// reputations returns the reputation of every chain.
+func reputations(bundlers []*bundler) []*reputationManager {
+	reps := make([]*reputationManager, len(bundlers))
+	for i, b := range bundlers {
+		reps[i] = b.reputation
+	}
+	return reps
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// L2 types of chain profiles.
+const (
+	l2None = ""
+	// l2OPStack chains price gas with their own EIP-1559 parameters.
+	l2OPStack = "opstack"
+	// l2Arbitrum chains have a fixed base fee floor set by the sequencer.
+	l2Arbitrum = "arbitrum"
+)
+
+// chainProfile is what the bundler needs to know about a network.
+type chainProfile struct {
+	Name    string
+	ChainID uint64
+	// Config is used to calculate the base fee and for in-process simulation.
+	Config *params.ChainConfig
+	// EntryPoints are the EntryPoints ops are accepted for.
+	EntryPoints []common.Address
+	// EntryPointVersions are the versions of the EntryPoints, detected from
+	// their code at startup.
+	EntryPointVersions map[common.Address]entryPointVersion
+	// MinPriorityFee (wei) is the lowest maxPriorityFeePerGas the fee oracle
+	// recommends and accepts, whatever recent blocks paid.
+	MinPriorityFee *big.Int
+	// L2 is the L2 type, l2None for L1 chains. The base fee of L2s is read
+	// from the latest block instead of calculated with Config.
+	L2 string
+}
+
+// entryPointV06 is the canonical deployment of EntryPoint v0.6.
+var entryPointV06 = common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+
+// chainProfiles are the networks shipped with the bundler, by name. Devnets
+// have no EntryPoint deployed at a known address; it is taken from
+// ENTRYPOINT_CONTRACT.
+var chainProfiles = map[string]*chainProfile{
+	"mainnet": {
+		Name:           "mainnet",
+		ChainID:        1,
+		Config:         params.MainnetChainConfig,
+		EntryPoints:    []common.Address{entryPointV06},
+		MinPriorityFee: big.NewInt(params.GWei),
+	},
+	"goerli": {
+		Name:           "goerli",
+		ChainID:        5,
+		Config:         params.GoerliChainConfig,
+		EntryPoints:    []common.Address{entryPointV06},
+		MinPriorityFee: big.NewInt(params.GWei),
+	},
+	"sepolia": {
+		Name:           "sepolia",
+		ChainID:        11155111,
+		Config:         params.SepoliaChainConfig,
+		EntryPoints:    []common.Address{entryPointV06},
+		MinPriorityFee: big.NewInt(params.GWei / 10),
+	},
+	"holesky": {
+		Name:           "holesky",
+		ChainID:        17000,
+		Config:         postMergeChainConfig(17000),
+		EntryPoints:    []common.Address{entryPointV06},
+		MinPriorityFee: big.NewInt(params.GWei / 10),
+	},
+	"dev": {
+		Name:           "dev",
+		ChainID:        1337,
+		Config:         postMergeChainConfig(1337),
+		MinPriorityFee: new(big.Int),
+	},
+	"anvil": {
+		Name:           "anvil",
+		ChainID:        31337,
+		Config:         postMergeChainConfig(31337),
+		MinPriorityFee: new(big.Int),
+	},
+}
+
+// postMergeChainConfig returns the config of a chain that started with every
+// fork up to the merge enabled.
+func postMergeChainConfig(chainID int64) *params.ChainConfig {
+	config := *params.AllEthashProtocolChanges
+	config.ChainID = big.NewInt(chainID)
+	config.Ethash = nil
+	config.TerminalTotalDifficulty = new(big.Int)
+	config.TerminalTotalDifficultyPassed = true
+	return &config
+}
+
+// profileByChainID returns the shipped profile of chainID.
+func profileByChainID(chainID uint64) (*chainProfile, bool) {
+	for _, p := range chainProfiles {
+		if p.ChainID == chainID {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// loadChainProfile returns the profile of the chain conn is connected to,
+// with the overrides of settings applied. If settings name a network, the node
+// must be on that chain. The EntryPoint bundles are sent to is listed first.
+func loadChainProfile(conn *ethclient.Client, settings *chainSettings) (*chainProfile, error) {
+	network := settings.Network
+	id, err := conn.ChainID(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain ID: %w", err)
+	}
+	var profile *chainProfile
+	if network != "" {
+		p, ok := chainProfiles[network]
+		if !ok {
+			return nil, fmt.Errorf("unknown network %q, known networks are %s", network, knownNetworks())
+		}
+		if !id.IsUint64() || id.Uint64() != p.ChainID {
+			return nil, fmt.Errorf("node is on chain %v, but NETWORK %s is chain %d", id, network, p.ChainID)
+		}
+		profile = p
+	} else {
+		p, ok := profileByChainID(id.Uint64())
+		if !id.IsUint64() || !ok {
+			return nil, fmt.Errorf("no profile for chain %v, known networks are %s", id, knownNetworks())
+		}
+		profile = p
+	}
+
+	c := *profile
+	if len(settings.EntryPoints) > 0 {
+		c.EntryPoints = settings.EntryPoints
+	}
+	if settings.MinPriorityFee != nil {
+		c.MinPriorityFee = settings.MinPriorityFee
+	}
+	if raw := settings.EntryPoint; raw != "" {
+		if !common.IsHexAddress(raw) {
+			return nil, fmt.Errorf("ENTRYPOINT_CONTRACT: invalid address %q", raw)
+		}
+		ep := common.HexToAddress(raw)
+		if len(c.EntryPoints) > 0 && !containsAddress(c.EntryPoints, ep) {
+			return nil, fmt.Errorf("ENTRYPOINT_CONTRACT %s is not an EntryPoint of %s", ep, c.Name)
+		}
+		others := []common.Address{ep}
+		for _, addr := range c.EntryPoints {
+			if addr != ep {
+				others = append(others, addr)
+			}
+		}
+		c.EntryPoints = others
+	}
+	if len(c.EntryPoints) == 0 {
+		return nil, fmt.Errorf("%s has no known EntryPoint, set ENTRYPOINT_CONTRACT", c.Name)
+	}
+	return &c, nil
+}
+
+// version returns the version of the EntryPoint ep, v0.6 if it was not
+// detected.
+func (c *chainProfile) version(ep common.Address) entryPointVersion {
+	if v, ok := c.EntryPointVersions[ep]; ok {
+		return v
+	}
+	return entryPointVersion06
+}
+
+// supportsEntryPoint tells if ops for ep are accepted.
+func (c *chainProfile) supportsEntryPoint(ep common.Address) bool {
+	return containsAddress(c.EntryPoints, ep)
+}
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func knownNetworks() string {
+	names := make([]string, 0, len(chainProfiles))
+	for name := range chainProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
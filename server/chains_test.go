@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeChainID answers eth_chainId.
+type fakeChainID uint64
+
+func (f fakeChainID) ChainId() hexutil.Uint64 {
+	return hexutil.Uint64(f)
+}
+
+func dialChainID(t *testing.T, id uint64) *ethclient.Client {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", fakeChainID(id)); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	t.Cleanup(client.Close)
+	return ethclient.NewClient(client)
+}
+
+func TestLoadChainProfile(t *testing.T) {
+	sepolia := dialChainID(t, 11155111)
+
+	profile, err := loadChainProfile(sepolia, &chainSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "sepolia" || profile.Config.ChainID.Uint64() != 11155111 {
+		t.Fatalf("detected %s (config chain %v), want sepolia", profile.Name, profile.Config.ChainID)
+	}
+	if _, err := loadChainProfile(sepolia, &chainSettings{Network: "mainnet"}); err == nil {
+		t.Fatal("loaded mainnet profile for a sepolia node")
+	}
+	if _, err := loadChainProfile(dialChainID(t, 424242), &chainSettings{}); err == nil {
+		t.Fatal("loaded a profile for an unknown chain")
+	}
+}
+
+func TestLoadChainProfileEntryPoints(t *testing.T) {
+	custom := common.HexToAddress("0xe1")
+	settings := &chainSettings{EntryPoint: custom.Hex()}
+
+	if _, err := loadChainProfile(dialChainID(t, 1), settings); err == nil {
+		t.Fatal("accepted an ENTRYPOINT_CONTRACT the profile does not list")
+	}
+	settings.EntryPoints = []common.Address{entryPointV06, custom}
+	profile, err := loadChainProfile(dialChainID(t, 1), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.EntryPoints[0] != custom || !profile.supportsEntryPoint(entryPointV06) {
+		t.Fatalf("got EntryPoints %v, want %s first", profile.EntryPoints, custom)
+	}
+	if chainProfiles["mainnet"].EntryPoints[0] != entryPointV06 {
+		t.Fatal("overrides changed the shipped profile")
+	}
+
+	settings.EntryPoints = nil
+	devnet, err := loadChainProfile(dialChainID(t, 31337), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devnet.EntryPoints) != 1 || devnet.EntryPoints[0] != custom {
+		t.Fatalf("got devnet EntryPoints %v, want [%s]", devnet.EntryPoints, custom)
+	}
+}
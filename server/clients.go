@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// upstream is one node endpoint.
+type upstream struct {
+	url    *url.URL
+	client *rpc.Client // used for health checks only
+
+	head    uint64
+	healthy bool
+}
+
+// clientManager shares one long-lived connection to the nodes between every
+// handler. Requests go to the healthiest node, the one with the highest head,
+// and are retried on the next one when the request fails in transport. Nodes
+// whose head lags the best one by more than maxLag blocks, or that fail the
+// health check, are only used when no other is left.
+type clientManager struct {
+	mu        sync.RWMutex
+	upstreams []*upstream
+	maxLag    uint64
+	interval  time.Duration
+
+	rpc *rpc.Client
+	eth *ethclient.Client
+}
+
+// newClientManager connects to urls. Failover between several nodes is only
+// supported over HTTP(S); a single node may use any transport.
+func newClientManager(urls []string, maxLag uint64, interval time.Duration) (*clientManager, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no node URL configured")
+	}
+	m := &clientManager{maxLag: maxLag, interval: interval}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node URL %q: %w", raw, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			if len(urls) > 1 {
+				return nil, fmt.Errorf("node URL %q: failover needs http(s) URLs", raw)
+			}
+			client, err := rpc.Dial(raw)
+			if err != nil {
+				return nil, err
+			}
+			m.upstreams = []*upstream{{url: u, client: client, healthy: true}}
+			m.rpc, m.eth = client, ethclient.NewClient(client)
+			return m, nil
+		}
+		client, err := rpc.DialHTTP(raw)
+		if err != nil {
+			return nil, err
+		}
+		m.upstreams = append(m.upstreams, &upstream{url: u, client: client, healthy: true})
+	}
+	// the URL is rewritten per request by RoundTrip
+	client, err := rpc.DialHTTPWithClient(urls[0], &http.Client{Transport: m})
+	if err != nil {
+		return nil, err
+	}
+	m.rpc, m.eth = client, ethclient.NewClient(client)
+	m.check()
+	return m, nil
+}
+
+// RPC returns the shared RPC client.
+func (m *clientManager) RPC() *rpc.Client {
+	return m.rpc
+}
+
+// Eth returns the shared client for the eth namespace.
+func (m *clientManager) Eth() *ethclient.Client {
+	return m.eth
+}
+
+// run health checks the nodes every interval until the process exits.
+func (m *clientManager) run() {
+	if len(m.upstreams) < 2 {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.check()
+	}
+}
+
+// check fetches the head of every node and marks the ones that fail or lag
+// behind as unhealthy.
+func (m *clientManager) check() {
+	heads := make([]uint64, len(m.upstreams))
+	errs := make([]error, len(m.upstreams))
+	var wg sync.WaitGroup
+	for i, u := range m.upstreams {
+		wg.Add(1)
+		go func(i int, u *upstream) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+			defer cancel()
+			var head hexutil.Uint64
+			errs[i] = u.client.CallContext(ctx, &head, "eth_blockNumber")
+			heads[i] = uint64(head)
+		}(i, u)
+	}
+	wg.Wait()
+
+	best := uint64(0)
+	for i := range m.upstreams {
+		if errs[i] == nil && heads[i] > best {
+			best = heads[i]
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, u := range m.upstreams {
+		healthy := errs[i] == nil && best-heads[i] <= m.maxLag
+		if healthy != u.healthy {
+			log.Warn("node health changed", "url", redactURL(u.url), "healthy", healthy, "head", heads[i], "best", best, "error", errs[i])
+		}
+		u.healthy = healthy
+		if errs[i] == nil {
+			u.head = heads[i]
+		}
+	}
+}
+
+// ordered returns the nodes from healthiest to least healthy.
+func (m *clientManager) ordered() []*upstream {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ordered := append([]*upstream(nil), m.upstreams...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].healthy != ordered[j].healthy {
+			return ordered[i].healthy
+		}
+		return ordered[i].head > ordered[j].head
+	})
+	return ordered
+}
+
+func (m *clientManager) markUnhealthy(u *upstream, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u.healthy {
+		log.Warn("node failed, failing over", "url", redactURL(u.url), "error", err)
+	}
+	u.healthy = false
+}
+
+// RoundTrip sends an RPC request to the healthiest node, and to the next ones
+// while it fails in transport or with a server error.
+func (m *clientManager) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	var lastErr error
+	for _, u := range m.ordered() {
+		out := req.Clone(req.Context())
+		out.URL = u.url
+		out.Host = u.url.Host
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("HTTP %s", resp.Status)
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		m.markUnhealthy(u, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// redactURL hides credentials and API keys in node URLs from logs.
+func redactURL(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// nodeURLs splits a comma separated list of node URLs.
+func nodeURLs(list string) []string {
+	var urls []string
+	for _, u := range strings.Split(list, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
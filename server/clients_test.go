@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeHead answers eth_blockNumber with a fixed head.
+type fakeHead struct {
+	head  uint64
+	calls int
+}
+
+func (f *fakeHead) BlockNumber() hexutil.Uint64 {
+	f.calls++
+	return hexutil.Uint64(f.head)
+}
+
+func newFakeNode(t *testing.T, head uint64) (*httptest.Server, *fakeHead) {
+	eth := &fakeHead{head: head}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", eth); err != nil {
+		t.Fatal(err)
+	}
+	node := httptest.NewServer(server)
+	t.Cleanup(node.Close)
+	return node, eth
+}
+
+func TestClientManagerRoutesToHealthiest(t *testing.T) {
+	lagging, laggingEth := newFakeNode(t, 100)
+	best, bestEth := newFakeNode(t, 110)
+	nodes, err := newClientManager([]string{lagging.URL, best.URL}, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laggingEth.calls, bestEth.calls = 0, 0
+
+	if _, err := nodes.Eth().BlockNumber(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if laggingEth.calls != 0 || bestEth.calls != 1 {
+		t.Fatalf("lagging node got %d calls, best got %d, want 0 and 1", laggingEth.calls, bestEth.calls)
+	}
+}
+
+func TestClientManagerFailsOver(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	backup, backupEth := newFakeNode(t, 100)
+	nodes, err := newClientManager([]string{failing.URL, backup.URL}, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pretend the failing node passed its last health check
+	for _, u := range nodes.upstreams {
+		u.healthy, u.head = true, 200
+	}
+	backupEth.calls = 0
+
+	head, err := nodes.Eth().BlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != 100 || backupEth.calls != 1 {
+		t.Fatalf("got head %d after %d backup calls, want 100 after 1", head, backupEth.calls)
+	}
+	if nodes.ordered()[0].url.String() != backup.URL {
+		t.Fatalf("failing node was not demoted")
+	}
+}
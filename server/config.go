@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// bundlerConfig holds the settings that are read once at startup instead of
+// on every request.
+type bundlerConfig struct {
+	// ListenAddr is the address the RPC server listens on.
+	ListenAddr string
+
+	// Chains are the chains bundled for, each with its own nodes, signer and
+	// mempool.
+	Chains []*chainSettings
+
+	// NodeMaxLag is how many blocks a node may be behind the best one before
+	// it is considered unhealthy.
+	NodeMaxLag uint64
+	// NodeHealthInterval is how often the nodes are health checked.
+	NodeHealthInterval time.Duration
+
+	// Metrics enables metric collection, served on /debug/metrics.
+	Metrics bool
+
+	// MinStake (wei) and MinUnstakeDelay (seconds) are what paymasters, and
+	// factories that need to be staked, must have locked in the EntryPoint.
+	MinStake        *big.Int
+	MinUnstakeDelay uint64
+
+	// Simulator is where validation is simulated and traced, simulatorNode or
+	// simulatorEVM.
+	Simulator string
+	// SimulatePending simulates validation against the pending block instead
+	// of the latest one.
+	SimulatePending bool
+
+	// SimCacheSize is how many simulations are cached per chain. Zero
+	// disables the cache.
+	SimCacheSize uint64
+
+	// ABIDir holds contract ABIs whose custom errors are decoded in revert
+	// reasons.
+	ABIDir string
+
+	// MinValidity is how long an op must stay valid after it is received.
+	MinValidity time.Duration
+	// MaxVerificationGas is the highest verificationGasLimit accepted.
+	MaxVerificationGas *big.Int
+
+	// GasOverheads are used to calculate the required preVerificationGas.
+	GasOverheads gasOverheads
+}
+
+// chainSettings are the settings of one chain.
+type chainSettings struct {
+	// Name is the chain's entry in CHAINS, empty when CHAINS is not set.
+	Name string
+
+	// NodeURLs are the nodes requests are sent to, the healthiest first.
+	NodeURLs []string
+
+	// Network names the chain profile to use. When empty it is picked by the
+	// node's chain ID.
+	Network string
+	// EntryPoint is the EntryPoint bundles are sent to.
+	EntryPoint string
+	// EntryPoints replace the profile's EntryPoints if not empty.
+	EntryPoints []common.Address
+	// EntryPointVersions set the version of EntryPoints, overriding the one
+	// detected from their code. EntryPoints with unknown code need one.
+	EntryPointVersions map[common.Address]entryPointVersion
+	// EntryPointCodeHashes are EntryPoint code hashes known in addition to
+	// knownEntryPointCode.
+	EntryPointCodeHashes map[common.Hash]entryPointVersion
+	// MinPriorityFee overrides the profile's priority fee floor if not nil.
+	MinPriorityFee *big.Int
+	// FeeHistoryBlocks is how many recent blocks the fee oracle reads the
+	// priority fees of.
+	FeeHistoryBlocks uint64
+	// FeeMargin is the percentage the fee oracle adds to the priority fees
+	// paid on chain.
+	FeeMargin uint64
+
+	// KeyIn and Passphrase unlock the signer's keystore file.
+	KeyIn      string
+	Passphrase string
+
+	// BalanceSoftThreshold is the signer balance (wei) below which a warning is
+	// logged on every block.
+	BalanceSoftThreshold *big.Int
+	// BalanceHardThreshold is the signer balance (wei) below which bundling is
+	// paused. User operations are still accepted into the mempool.
+	BalanceHardThreshold *big.Int
+	// BlockPollInterval is how often the node is polled for a new block.
+	BlockPollInterval time.Duration
+	// BundleInterval is the least time between two bundles. Zero bundles on
+	// every block.
+	BundleInterval time.Duration
+	// MempoolMaxOps is how many ops the mempool holds. Zero is no limit.
+	MempoolMaxOps uint64
+
+	// Beneficiary receives the handleOps gas refunds. The zero address means
+	// the signing EOA. BeneficiaryKeyIn and BeneficiaryPassphrase unlock its
+	// keystore file, if we hold it.
+	Beneficiary           common.Address
+	BeneficiaryKeyIn      string
+	BeneficiaryPassphrase string
+	// ColdWallet receives the beneficiary balance above SweepFloat every
+	// SweepInterval. Sweeping is disabled when it is the zero address or the
+	// interval is zero.
+	ColdWallet    common.Address
+	SweepFloat    *big.Int
+	SweepInterval time.Duration
+	// AccountingLog is the file every sweep is recorded in.
+	AccountingLog string
+
+	// ReputationFile is where entity reputation is persisted.
+	ReputationFile string
+}
+
+var conf *bundlerConfig
+
+func loadConfig() (*bundlerConfig, error) {
+	var chains []*chainSettings
+	names := strings.Split(os.Getenv("CHAINS"), ",")
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" && len(names) > 1 {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("CHAINS: %q is listed twice", name)
+		}
+		seen[name] = true
+		chain, err := loadChainSettings(name)
+		if err != nil {
+			return nil, err
+		}
+		chains = append(chains, chain)
+	}
+	maxLag, err := envUint("NODE_MAX_LAG", 2)
+	if err != nil {
+		return nil, err
+	}
+	healthInterval, err := envDuration("NODE_HEALTH_INTERVAL", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if healthInterval <= 0 {
+		return nil, fmt.Errorf("NODE_HEALTH_INTERVAL must be positive")
+	}
+	minStake, err := envWei("MIN_STAKE", big.NewInt(1e18)) // 1 ETH
+	if err != nil {
+		return nil, err
+	}
+	minUnstakeDelay, err := envUint("MIN_UNSTAKE_DELAY", 86400)
+	if err != nil {
+		return nil, err
+	}
+	simulator := os.Getenv("SIMULATOR")
+	if simulator == "" {
+		simulator = simulatorNode
+	}
+	if simulator != simulatorNode && simulator != simulatorEVM {
+		return nil, fmt.Errorf("SIMULATOR: must be %s or %s, got %q", simulatorNode, simulatorEVM, simulator)
+	}
+	simulationBlock := os.Getenv("SIMULATION_BLOCK")
+	if simulationBlock != "" && simulationBlock != "latest" && simulationBlock != "pending" {
+		return nil, fmt.Errorf("SIMULATION_BLOCK: must be latest or pending, got %q", simulationBlock)
+	}
+	minValidity, err := envDuration("MIN_VALIDITY", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	maxVerificationGas, err := envWei("MAX_VERIFICATION_GAS", big.NewInt(100e9))
+	if err != nil {
+		return nil, err
+	}
+	listenAddr := os.Getenv("LISTEN_ADDR")
+	if listenAddr == "" {
+		listenAddr = ":8080"
+	}
+	if _, _, err := net.SplitHostPort(listenAddr); err != nil {
+		return nil, fmt.Errorf("LISTEN_ADDR: %w", err)
+	}
+	simCacheSize, err := envUint("SIM_CACHE_SIZE", 1024)
+	if err != nil {
+		return nil, err
+	}
+	overheads, err := loadGasOverheads()
+	if err != nil {
+		return nil, err
+	}
+	return &bundlerConfig{
+		ListenAddr:         listenAddr,
+		Chains:             chains,
+		NodeMaxLag:         maxLag,
+		NodeHealthInterval: healthInterval,
+		Metrics:            os.Getenv("METRICS") == "true",
+		MinStake:           minStake,
+		MinUnstakeDelay:    minUnstakeDelay,
+		Simulator:          simulator,
+		SimulatePending:    simulationBlock == "pending",
+		SimCacheSize:       simCacheSize,
+		ABIDir:             os.Getenv("ABI_DIR"),
+		MinValidity:        minValidity,
+		MaxVerificationGas: maxVerificationGas,
+		GasOverheads:       overheads,
+	}, nil
+}
+
+// chainEnv names the environment variables of a chain: the variables of the
+// chain "sepolia" are prefixed with SEPOLIA_, falling back to the unprefixed
+// ones.
+type chainEnv string
+
+func (c chainEnv) prefix() string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, string(c)) + "_"
+}
+
+// key returns the variable to read key from.
+func (c chainEnv) key(key string) string {
+	if c != "" {
+		if _, ok := os.LookupEnv(c.prefix() + key); ok {
+			return c.prefix() + key
+		}
+	}
+	return key
+}
+
+func (c chainEnv) get(key string) string {
+	return os.Getenv(c.key(key))
+}
+
+// file returns the path in key, or def. Unless the chain sets it itself, the
+// chain's name is added to the file name, so that chains never share a file.
+func (c chainEnv) file(key, def string) string {
+	path := os.Getenv(c.key(key))
+	if path == "" {
+		path = def
+	}
+	if c == "" || c.key(key) != key {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + string(c) + ext
+}
+
+// loadChainSettings reads the settings of the chain name from the
+// environment.
+func loadChainSettings(name string) (*chainSettings, error) {
+	env := chainEnv(name)
+	soft, err := envWei(env.key("BALANCE_SOFT_THRESHOLD"), big.NewInt(1e17)) // 0.1 ETH
+	if err != nil {
+		return nil, err
+	}
+	hard, err := envWei(env.key("BALANCE_HARD_THRESHOLD"), big.NewInt(1e16)) // 0.01 ETH
+	if err != nil {
+		return nil, err
+	}
+	if hard.Cmp(soft) > 0 {
+		return nil, fmt.Errorf("%s (%v) is above %s (%v)", env.key("BALANCE_HARD_THRESHOLD"), hard, env.key("BALANCE_SOFT_THRESHOLD"), soft)
+	}
+	poll, err := envDuration(env.key("BLOCK_POLL_INTERVAL"), 4*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if poll <= 0 {
+		return nil, fmt.Errorf("%s must be positive", env.key("BLOCK_POLL_INTERVAL"))
+	}
+	bundleInterval, err := envDuration(env.key("BUNDLE_INTERVAL"), 0)
+	if err != nil {
+		return nil, err
+	}
+	mempoolMaxOps, err := envUint(env.key("MEMPOOL_MAX_OPS"), 4096)
+	if err != nil {
+		return nil, err
+	}
+	urls := nodeURLs(env.get("NODE_URLS"))
+	if len(urls) == 0 {
+		urls = nodeURLs(env.get("CLIENT"))
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s or %s must be set", env.key("NODE_URLS"), env.key("CLIENT"))
+	}
+	entryPoints, err := envAddresses(env.key("ENTRY_POINTS"))
+	if err != nil {
+		return nil, err
+	}
+	entryPointVersions, err := envEntryPointVersions(env.key("ENTRY_POINT_VERSIONS"))
+	if err != nil {
+		return nil, err
+	}
+	entryPointCodeHashes, err := envCodeHashVersions(env.key("ENTRY_POINT_CODE_HASHES"))
+	if err != nil {
+		return nil, err
+	}
+	minPriorityFee, err := envWei(env.key("MIN_PRIORITY_FEE"), nil)
+	if err != nil {
+		return nil, err
+	}
+	feeHistoryBlocks, err := envUint(env.key("FEE_HISTORY_BLOCKS"), 20)
+	if err != nil {
+		return nil, err
+	}
+	if feeHistoryBlocks == 0 || feeHistoryBlocks > 1024 {
+		return nil, fmt.Errorf("%s must be between 1 and 1024", env.key("FEE_HISTORY_BLOCKS"))
+	}
+	feeMargin, err := envUint(env.key("FEE_MARGIN"), 10)
+	if err != nil {
+		return nil, err
+	}
+	// TEMP_BENEFICIARY is still honoured for existing deployments
+	beneficiary, err := envAddress(env.key("BENEFICIARY"), os.Getenv("TEMP_BENEFICIARY"))
+	if err != nil {
+		return nil, err
+	}
+	coldWallet, err := envAddress(env.key("COLD_WALLET"), "")
+	if err != nil {
+		return nil, err
+	}
+	float, err := envWei(env.key("SWEEP_FLOAT"), big.NewInt(1e18)) // 1 ETH
+	if err != nil {
+		return nil, err
+	}
+	sweepInterval, err := envDuration(env.key("SWEEP_INTERVAL"), 0)
+	if err != nil {
+		return nil, err
+	}
+	network := env.get("NETWORK")
+	if network == "" {
+		if _, ok := chainProfiles[name]; ok {
+			network = name
+		}
+	} else if _, ok := chainProfiles[network]; !ok {
+		return nil, fmt.Errorf("%s: unknown network %q, known networks are %s", env.key("NETWORK"), network, knownNetworks())
+	}
+	if ep := env.get("ENTRYPOINT_CONTRACT"); ep != "" && !common.IsHexAddress(ep) {
+		return nil, fmt.Errorf("%s: invalid address %q", env.key("ENTRYPOINT_CONTRACT"), ep)
+	}
+	if env.get("KEY_IN") == "" {
+		return nil, fmt.Errorf("%s must be set", env.key("KEY_IN"))
+	}
+	return &chainSettings{
+		Name:                  name,
+		NodeURLs:              urls,
+		Network:               network,
+		EntryPoint:            env.get("ENTRYPOINT_CONTRACT"),
+		EntryPoints:           entryPoints,
+		EntryPointVersions:    entryPointVersions,
+		EntryPointCodeHashes:  entryPointCodeHashes,
+		MinPriorityFee:        minPriorityFee,
+		FeeHistoryBlocks:      feeHistoryBlocks,
+		FeeMargin:             feeMargin,
+		KeyIn:                 env.get("KEY_IN"),
+		Passphrase:            env.get("PASSPHRASE"),
+		BalanceSoftThreshold:  soft,
+		BalanceHardThreshold:  hard,
+		BlockPollInterval:     poll,
+		BundleInterval:        bundleInterval,
+		MempoolMaxOps:         mempoolMaxOps,
+		Beneficiary:           beneficiary,
+		BeneficiaryKeyIn:      env.get("BENEFICIARY_KEY_IN"),
+		BeneficiaryPassphrase: env.get("BENEFICIARY_PASSPHRASE"),
+		ColdWallet:            coldWallet,
+		SweepFloat:            float,
+		SweepInterval:         sweepInterval,
+		AccountingLog:         env.file("ACCOUNTING_LOG", "accounting.log"),
+		ReputationFile:        env.file("REPUTATION_FILE", "reputation.json"),
+	}, nil
+}
+
+func loadGasOverheads() (gasOverheads, error) {
+	o := defaultGasOverheads
+	for _, v := range []struct {
+		key   string
+		value *uint64
+	}{
+		{"PVG_FIXED", &o.Fixed},
+		{"PVG_BUNDLE_SIZE", &o.BundleSize},
+		{"PVG_PER_USER_OP", &o.PerUserOp},
+		{"PVG_PER_USER_OP_WORD", &o.PerUserOpWord},
+		{"PVG_ZERO_BYTE", &o.ZeroByte},
+		{"PVG_NON_ZERO_BYTE", &o.NonZeroByte},
+	} {
+		n, err := envUint(v.key, *v.value)
+		if err != nil {
+			return o, err
+		}
+		*v.value = n
+	}
+	if o.BundleSize == 0 {
+		return o, fmt.Errorf("PVG_BUNDLE_SIZE must be at least 1")
+	}
+	return o, nil
+}
+
+// beneficiary returns the configured beneficiary, defaulting to signer.
+func (c *chainSettings) beneficiary(signer common.Address) common.Address {
+	if c.Beneficiary == zeroAddress {
+		return signer
+	}
+	return c.Beneficiary
+}
+
+func envWei(key string, def *big.Int) (*big.Int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	wei, err := parseWei(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return wei, nil
+}
+
+// envAddresses parses a comma separated list of addresses.
+func envAddresses(key string) ([]common.Address, error) {
+	var addrs []common.Address
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !common.IsHexAddress(v) {
+			return nil, fmt.Errorf("%s: invalid address %q", key, v)
+		}
+		addrs = append(addrs, common.HexToAddress(v))
+	}
+	return addrs, nil
+}
+
+// envEntryPointVersions parses a comma separated list of address=version
+// pairs.
+func envEntryPointVersions(key string) (map[common.Address]entryPointVersion, error) {
+	pairs, err := envVersionPairs(key, "address", common.IsHexAddress)
+	if err != nil {
+		return nil, err
+	}
+	versions := map[common.Address]entryPointVersion{}
+	for addr, version := range pairs {
+		versions[common.HexToAddress(addr)] = version
+	}
+	return versions, nil
+}
+
+// envCodeHashVersions parses a comma separated list of codehash=version
+// pairs.
+func envCodeHashVersions(key string) (map[common.Hash]entryPointVersion, error) {
+	pairs, err := envVersionPairs(key, "codehash", isHexHash)
+	if err != nil {
+		return nil, err
+	}
+	versions := map[common.Hash]entryPointVersion{}
+	for hash, version := range pairs {
+		versions[common.HexToHash(hash)] = version
+	}
+	return versions, nil
+}
+
+func isHexHash(s string) bool {
+	b, err := hexutil.Decode(s)
+	return err == nil && len(b) == common.HashLength
+}
+
+// envVersionPairs parses a comma separated list of what=version pairs whose
+// left side passes valid.
+func envVersionPairs(key, what string, valid func(string) bool) (map[string]entryPointVersion, error) {
+	versions := map[string]entryPointVersion{}
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		left, version, ok := strings.Cut(v, "=")
+		if left = strings.TrimSpace(left); !ok || !valid(left) {
+			return nil, fmt.Errorf("%s: %q is not %s=version", key, v, what)
+		}
+		parsed, err := parseEntryPointVersion(strings.TrimSpace(version))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		versions[left] = parsed
+	}
+	return versions, nil
+}
+
+func envAddress(key string, def string) (common.Address, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		v = def
+	}
+	if v == "" {
+		return zeroAddress, nil
+	}
+	if !common.IsHexAddress(v) {
+		return zeroAddress, fmt.Errorf("%s: invalid address %q", key, v)
+	}
+	return common.HexToAddress(v), nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
+
+func envUint(key string, def uint64) (uint64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
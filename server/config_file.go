@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+)
+
+// configKey is a setting that can come from a flag, the environment or the
+// config file. In the config file it is the lower case variable name
+// (node_urls), as a flag the same with dashes (-node-urls). Flags set the
+// shared value of chain settings.
+type configKey struct {
+	name string
+	// chain keys can be set per chain, in [[chains]] tables or with the
+	// chain's prefix.
+	chain bool
+	usage string
+}
+
+// configKeys are all the settings of the bundler.
+var configKeys = []configKey{
+	{"LISTEN_ADDR", false, "address the RPC server listens on (default :8080)"},
+	{"CHAINS", false, "comma separated names of the chains to bundle for"},
+	{"NODE_MAX_LAG", false, "blocks a node may lag behind the best one (default 2)"},
+	{"NODE_HEALTH_INTERVAL", false, "how often nodes are health checked (default 5s)"},
+	{"METRICS", false, "serve metrics on /debug/metrics when true"},
+	{"MIN_STAKE", false, "stake (wei) required of staked entities (default 1 ETH)"},
+	{"MIN_UNSTAKE_DELAY", false, "unstake delay (seconds) required of staked entities (default 86400)"},
+	{"SIMULATOR", false, "where validation is simulated, node or evm (default node)"},
+	{"SIMULATION_BLOCK", false, "block validation is simulated at, latest or pending (default latest)"},
+	{"SIM_CACHE_SIZE", false, "simulations cached per chain, 0 disables the cache (default 1024)"},
+	{"ABI_DIR", false, "directory of ABIs to decode custom errors with"},
+	{"MIN_VALIDITY", false, "how long ops must stay valid after they are received (default 30s)"},
+	{"MAX_VERIFICATION_GAS", false, "highest verificationGasLimit accepted (default 100000000000)"},
+	{"PVG_FIXED", false, "preVerificationGas: fixed overhead of a bundle"},
+	{"PVG_BUNDLE_SIZE", false, "preVerificationGas: ops the fixed overhead is shared by"},
+	{"PVG_PER_USER_OP", false, "preVerificationGas: overhead per op"},
+	{"PVG_PER_USER_OP_WORD", false, "preVerificationGas: overhead per op word"},
+	{"PVG_ZERO_BYTE", false, "preVerificationGas: calldata gas per zero byte"},
+	{"PVG_NON_ZERO_BYTE", false, "preVerificationGas: calldata gas per non zero byte"},
+
+	{"NODE_URLS", true, "comma separated node endpoints"},
+	{"CLIENT", true, "node endpoint, used when NODE_URLS is not set"},
+	{"NETWORK", true, "network profile the node must be on"},
+	{"ENTRYPOINT_CONTRACT", true, "EntryPoint bundles are sent to"},
+	{"ENTRY_POINTS", true, "comma separated EntryPoints ops are accepted for"},
+	{"ENTRY_POINT_VERSIONS", true, "comma separated address=version of EntryPoints, overriding the version detected from their code"},
+	{"ENTRY_POINT_CODE_HASHES", true, "comma separated codehash=version of EntryPoint releases to recognize"},
+	{"MIN_PRIORITY_FEE", true, "lowest maxPriorityFeePerGas (wei) ever accepted"},
+	{"FEE_HISTORY_BLOCKS", true, "blocks the fee oracle reads (default 20)"},
+	{"FEE_MARGIN", true, "percentage the fee oracle adds to recent tips (default 10)"},
+	{"KEY_IN", true, "signer keystore file"},
+	{"PASSPHRASE", true, "signer keystore passphrase"},
+	{"BALANCE_SOFT_THRESHOLD", true, "signer balance (wei) warned about (default 0.1 ETH)"},
+	{"BALANCE_HARD_THRESHOLD", true, "signer balance (wei) bundling pauses at (default 0.01 ETH)"},
+	{"BLOCK_POLL_INTERVAL", true, "how often the head is polled without a subscription (default 4s)"},
+	{"BUNDLE_INTERVAL", true, "least time between bundles, 0 bundles on every block (default 0)"},
+	{"MEMPOOL_MAX_OPS", true, "ops the mempool holds, 0 for no limit (default 4096)"},
+	{"BENEFICIARY", true, "receives the handleOps refunds (default the signer)"},
+	{"BENEFICIARY_KEY_IN", true, "beneficiary keystore file"},
+	{"BENEFICIARY_PASSPHRASE", true, "beneficiary keystore passphrase"},
+	{"COLD_WALLET", true, "receives the beneficiary balance above SWEEP_FLOAT"},
+	{"SWEEP_FLOAT", true, "beneficiary balance (wei) kept when sweeping (default 1 ETH)"},
+	{"SWEEP_INTERVAL", true, "how often the beneficiary is swept, 0 disables it"},
+	{"ACCOUNTING_LOG", true, "file sweeps are recorded in"},
+	{"REPUTATION_FILE", true, "file entity reputation is persisted to"},
+}
+
+func lookupConfigKey(name string) (configKey, bool) {
+	for _, k := range configKeys {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return configKey{}, false
+}
+
+// flagName is the flag of the setting name.
+func flagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+// parseFlags parses the flags in front of the command in args and sets the
+// variables of those given, overriding the environment. It returns the
+// command.
+func parseFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("bundler", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), commandUsage)
+		fmt.Fprintln(flags.Output(), "\nflags:")
+		flags.PrintDefaults()
+	}
+	flags.String("config", "", "TOML config file (default $CONFIG_FILE)")
+	for _, k := range configKeys {
+		flags.String(flagName(k.name), "", k.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		name := strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if f.Name == "config" {
+			name = "CONFIG_FILE"
+		}
+		if setErr := os.Setenv(name, f.Value.String()); setErr != nil && err == nil {
+			err = setErr
+		}
+	})
+	return flags.Args(), err
+}
+
+// loadEnvironment loads .env, if there is one, and then the config file named
+// by CONFIG_FILE. Neither overrides variables that are already set, so flags
+// win over the environment, then .env, then the config file.
+func loadEnvironment() error {
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf(".env: %w", err)
+	}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadConfigFile(path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// loadConfigFile sets the variables the TOML file at path configures, except
+// for those already set. Top level keys set the shared variables. Every
+// [[chains]] table sets the variables of the chain it names, with the
+// chain's prefix, and the chains are listed in CHAINS:
+//
+//	listen_addr = ":8080"
+//	max_verification_gas = 5000000
+//
+//	[[chains]]
+//	name = "sepolia"
+//	node_urls = ["https://a.example", "https://b.example"]
+//	key_in = "keys/sepolia.json"
+func loadConfigFile(path string) error {
+	var file map[string]interface{}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return err
+	}
+	vars, err := configFileVars(file)
+	if err != nil {
+		return err
+	}
+	for name, value := range vars {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configFileVars returns the variables set by the decoded config file.
+func configFileVars(file map[string]interface{}) (map[string]string, error) {
+	vars := map[string]string{}
+	// chains is either the list of names or the tables of the chains
+	chains, tables := file["chains"].([]map[string]interface{})
+	for key, value := range file {
+		if key == "chains" && tables {
+			continue
+		}
+		name := strings.ToUpper(key)
+		if _, ok := lookupConfigKey(name); !ok {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		v, err := configFileValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		vars[name] = v
+	}
+	if !tables {
+		return vars, nil
+	}
+	var names []string
+	for i, chain := range chains {
+		name, ok := chain["name"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("chains[%d]: missing name", i)
+		}
+		names = append(names, name)
+		prefix := chainEnv(name).prefix()
+		keys := make([]string, 0, len(chain))
+		for key := range chain {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "name" {
+				continue
+			}
+			k, ok := lookupConfigKey(strings.ToUpper(key))
+			if !ok || !k.chain {
+				return nil, fmt.Errorf("chains[%d] (%s): %q is not a chain setting", i, name, key)
+			}
+			v, err := configFileValue(chain[key])
+			if err != nil {
+				return nil, fmt.Errorf("chains[%d] (%s): %s: %w", i, name, key, err)
+			}
+			vars[prefix+k.name] = v
+		}
+	}
+	vars["CHAINS"] = strings.Join(names, ",")
+	return vars, nil
+}
+
+// configFileValue formats a config file value as a variable. Arrays become
+// comma separated lists.
+func configFileValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// runConfigCommand runs the config subcommands.
+func runConfigCommand(args []string) error {
+	if len(args) != 1 || args[0] != "check" {
+		return errors.New("missing or unknown config subcommand")
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := c.checkFiles(); err != nil {
+		return err
+	}
+	c.print()
+	fmt.Println("configuration is valid")
+	return nil
+}
+
+// checkFiles checks that the files the config names can be read, which is
+// otherwise only found out while starting up.
+func (c *bundlerConfig) checkFiles() error {
+	for _, chain := range c.Chains {
+		for _, path := range []string{chain.KeyIn, chain.BeneficiaryKeyIn} {
+			if path == "" {
+				continue
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("chain %q: %w", chain.Name, err)
+			}
+			f.Close()
+		}
+	}
+	if err := loadRevertABIs(c.ABIDir); err != nil {
+		return fmt.Errorf("ABI_DIR: %w", err)
+	}
+	return nil
+}
+
+// print prints the settings the bundler would run with. Passphrases and node
+// URL paths, which may hold API keys, are left out.
+func (c *bundlerConfig) print() {
+	fmt.Printf("listen address: %s\n", c.ListenAddr)
+	fmt.Printf("simulator: %s, pending block: %t, cache size: %d\n", c.Simulator, c.SimulatePending, c.SimCacheSize)
+	fmt.Printf("max verification gas: %v, min validity: %v\n", c.MaxVerificationGas, c.MinValidity)
+	for _, chain := range c.Chains {
+		name := chain.Name
+		if name == "" {
+			name = "(default)"
+		}
+		network := chain.Network
+		if network == "" {
+			network = "detected by chain ID"
+		}
+		nodes := make([]string, len(chain.NodeURLs))
+		for i, raw := range chain.NodeURLs {
+			if u, err := url.Parse(raw); err == nil && u.Host != "" {
+				nodes[i] = redactURL(u)
+			} else {
+				nodes[i] = raw
+			}
+		}
+		fmt.Printf("chain %s:\n", name)
+		fmt.Printf("  network: %s\n", network)
+		fmt.Printf("  nodes: %s\n", strings.Join(nodes, ", "))
+		if chain.EntryPoint != "" {
+			fmt.Printf("  entry point: %s\n", chain.EntryPoint)
+		}
+		if len(chain.EntryPoints) > 0 {
+			fmt.Printf("  entry points: %v\n", chain.EntryPoints)
+		}
+		fmt.Printf("  signer key: %s\n", chain.KeyIn)
+		fmt.Printf("  mempool max ops: %d, bundle interval: %v\n", chain.MempoolMaxOps, chain.BundleInterval)
+		fmt.Printf("  reputation file: %s, accounting log: %s\n", chain.ReputationFile, chain.AccountingLog)
+	}
+}
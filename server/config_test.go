@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLoadChainSettings(t *testing.T) {
+	t.Setenv("CLIENT", "http://shared:8545")
+	t.Setenv("KEY_IN", "shared.key")
+	t.Setenv("REPUTATION_FILE", "data/reputation.json")
+	t.Setenv("SEPOLIA_NODE_URLS", "http://a:8545, http://b:8545")
+	t.Setenv("SEPOLIA_KEY_IN", "sepolia.key")
+	t.Setenv("SEPOLIA_ACCOUNTING_LOG", "sepolia-sweeps.log")
+	t.Setenv("SEPOLIA_MIN_PRIORITY_FEE", "1000")
+
+	sepolia, err := loadChainSettings("sepolia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sepolia.NodeURLs) != 2 || sepolia.NodeURLs[1] != "http://b:8545" {
+		t.Errorf("NodeURLs = %v, want the SEPOLIA_NODE_URLS list", sepolia.NodeURLs)
+	}
+	if sepolia.Network != "sepolia" {
+		t.Errorf("Network = %q, want the chain name", sepolia.Network)
+	}
+	if sepolia.KeyIn != "sepolia.key" || sepolia.MinPriorityFee.Int64() != 1000 {
+		t.Errorf("prefixed settings not used: KeyIn %q, MinPriorityFee %v", sepolia.KeyIn, sepolia.MinPriorityFee)
+	}
+	if sepolia.ReputationFile != "data/reputation.sepolia.json" {
+		t.Errorf("ReputationFile = %q, want the shared file name with the chain added", sepolia.ReputationFile)
+	}
+	if sepolia.AccountingLog != "sepolia-sweeps.log" {
+		t.Errorf("AccountingLog = %q, want SEPOLIA_ACCOUNTING_LOG as is", sepolia.AccountingLog)
+	}
+
+	// a chain without its own variables falls back to the shared ones
+	other, err := loadChainSettings("my-devnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other.NodeURLs) != 1 || other.NodeURLs[0] != "http://shared:8545" || other.KeyIn != "shared.key" {
+		t.Errorf("fallback settings: NodeURLs %v, KeyIn %q", other.NodeURLs, other.KeyIn)
+	}
+	if other.Network != "" {
+		t.Errorf("Network = %q, want it detected for a chain without a profile name", other.Network)
+	}
+	if chainEnv("my-devnet").prefix() != "MY_DEVNET_" {
+		t.Errorf("prefix = %q", chainEnv("my-devnet").prefix())
+	}
+
+	single, err := loadChainSettings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.ReputationFile != "data/reputation.json" {
+		t.Errorf("single chain ReputationFile = %q, want it unchanged", single.ReputationFile)
+	}
+}
+
+func TestConfigFileVars(t *testing.T) {
+	vars, err := configFileVars(map[string]interface{}{
+		"listen_addr": ":9000",
+		"min_stake":   float64(1e18),
+		"chains": []map[string]interface{}{
+			{"name": "sepolia", "node_urls": []interface{}{"http://a:8545", "http://b:8545"}, "mempool_max_ops": int64(100)},
+			{"name": "my-devnet", "key_in": "devnet.key"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"LISTEN_ADDR":             ":9000",
+		"MIN_STAKE":               "1000000000000000000",
+		"CHAINS":                  "sepolia,my-devnet",
+		"SEPOLIA_NODE_URLS":       "http://a:8545,http://b:8545",
+		"SEPOLIA_MEMPOOL_MAX_OPS": "100",
+		"MY_DEVNET_KEY_IN":        "devnet.key",
+	}
+	if len(vars) != len(want) {
+		t.Errorf("got %d variables %v, want %d", len(vars), vars, len(want))
+	}
+	for name, value := range want {
+		if vars[name] != value {
+			t.Errorf("%s = %q, want %q", name, vars[name], value)
+		}
+	}
+
+	if _, err := configFileVars(map[string]interface{}{"max_verifcation_gas": int64(1)}); err == nil {
+		t.Error("accepted a misspelled setting")
+	}
+	if _, err := configFileVars(map[string]interface{}{
+		"chains": []map[string]interface{}{{"name": "sepolia", "min_stake": "1"}},
+	}); err == nil {
+		t.Error("accepted a shared setting in a chain table")
+	}
+}
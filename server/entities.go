@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// validationEntities are the contracts whose code runs during validation.
+type validationEntities struct {
+	Sender common.Address
+	// Factory is the first 20 bytes of InitCode.
+	Factory common.Address
+	// Paymaster is the first 20 bytes of PaymasterAndData.
+	Paymaster common.Address
+	// Aggregator is not part of the op. It is set from the simulation result
+	// when the account uses one.
+	Aggregator  common.Address
+	HasInitCode bool
+}
+
+// parseEntities extracts the factory and paymaster from op and checks the
+// length of the bytes that follow them.
+func parseEntities(op _UserOperation) (validationEntities, error) {
+	factory, factoryData, err := splitEntityField("initCode", op.InitCode)
+	if err != nil {
+		return validationEntities{}, err
+	}
+	if factory == zeroAddress && len(op.InitCode) > 0 {
+		return validationEntities{}, fmt.Errorf("initCode starts with the zero address followed by %d bytes of factory data", len(factoryData))
+	}
+	if factory != zeroAddress && len(factoryData) < 4 {
+		return validationEntities{}, fmt.Errorf("initCode must hold the factory address followed by at least a 4 byte selector, got %d bytes of factory data", len(factoryData))
+	}
+	paymaster, paymasterData, err := splitEntityField("paymasterAndData", op.PaymasterAndData)
+	if err != nil {
+		return validationEntities{}, err
+	}
+	if paymaster == zeroAddress && len(op.PaymasterAndData) > 0 {
+		return validationEntities{}, fmt.Errorf("paymasterAndData starts with the zero address followed by %d bytes of paymaster data", len(paymasterData))
+	}
+	return validationEntities{
+		Sender:      op.Sender,
+		Factory:     factory,
+		Paymaster:   paymaster,
+		HasInitCode: len(op.InitCode) != 0,
+	}, nil
+}
+
+// opEntities returns the entities of an op that already passed parseEntities.
+func opEntities(op _UserOperation) validationEntities {
+	entities, _ := parseEntities(op)
+	return entities
+}
+
+// splitEntityField splits a field that is either empty or an address followed
+// by arbitrary data.
+func splitEntityField(name string, field []byte) (common.Address, []byte, error) {
+	if len(field) == 0 {
+		return zeroAddress, nil, nil
+	}
+	if len(field) < common.AddressLength {
+		return zeroAddress, nil, fmt.Errorf("%s must be empty or start with a 20 byte address, got %d bytes", name, len(field))
+	}
+	return common.BytesToAddress(field[:common.AddressLength]), field[common.AddressLength:], nil
+}
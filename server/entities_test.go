@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestParseEntities(t *testing.T) {
+	factory := common.HexToAddress("0x00000000000000000000000000000000000000fa")
+	paymaster := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	selector := []byte{1, 2, 3, 4}
+
+	tests := []struct {
+		name             string
+		initCode         []byte
+		paymasterAndData []byte
+		want             validationEntities
+		wantErr          bool
+	}{
+		{name: "no entities"},
+		{
+			name:             "factory and paymaster",
+			initCode:         append(factory.Bytes(), selector...),
+			paymasterAndData: append(paymaster.Bytes(), 0xff),
+			want:             validationEntities{Factory: factory, Paymaster: paymaster, HasInitCode: true},
+		},
+		{name: "paymaster without data", paymasterAndData: paymaster.Bytes(), want: validationEntities{Paymaster: paymaster}},
+		{name: "short paymaster", paymasterAndData: paymaster.Bytes()[:19], wantErr: true},
+		{name: "short initCode", initCode: factory.Bytes()[:10], wantErr: true},
+		{name: "factory without selector", initCode: append(factory.Bytes(), 1, 2), wantErr: true},
+		{name: "paymaster data without paymaster", paymasterAndData: append(make([]byte, 20), 0xff), wantErr: true},
+		{name: "zero paymaster", paymasterAndData: make([]byte, 20), wantErr: true},
+		{name: "factory data without factory", initCode: append(make([]byte, 20), selector...), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEntities(_UserOperation{InitCode: tt.initCode, PaymasterAndData: tt.paymasterAndData})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
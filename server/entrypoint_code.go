@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// knownEntryPointCode are the runtime code hashes of the canonical EntryPoint
+// deployments, v0.6 at 0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789 and v0.7 at
+// 0x0000000071727De22E5E9d8BAf0edAc6f37da032, with their versions. Other
+// deployments are added from ENTRY_POINT_CODE_HASHES.
+var knownEntryPointCode = map[common.Hash]entryPointVersion{
+	common.HexToHash("0xc93c806e738300b5357ecdc2e971d6438d34d8e4e17b99b758b1f9cac91c8e70"): entryPointVersion06,
+	common.HexToHash("0x8db5ff695839d655407cc8490bb7a5d82337a86a6b39c3f0258aa6c3b582fc58"): entryPointVersion07,
+}
+
+// detectEntryPointVersions fetches the code at every EntryPoint and returns
+// their versions from its hash. A version set in ENTRY_POINT_VERSIONS wins
+// over the detected one, and is needed for EntryPoints with unknown code,
+// which are refused otherwise.
+func detectEntryPointVersions(conn *ethclient.Client, entryPoints []common.Address, settings *chainSettings) (map[common.Address]entryPointVersion, error) {
+	versions := map[common.Address]entryPointVersion{}
+	for _, ep := range entryPoints {
+		code, err := conn.CodeAt(context.Background(), ep, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get code of EntryPoint %s: %w", ep, err)
+		}
+		if len(code) == 0 {
+			return nil, fmt.Errorf("EntryPoint %s has no code", ep)
+		}
+		hash := crypto.Keccak256Hash(code)
+		detected, known := knownEntryPointCode[hash]
+		if !known {
+			detected, known = settings.EntryPointCodeHashes[hash]
+		}
+		override, overridden := settings.EntryPointVersions[ep]
+		switch {
+		case overridden:
+			if !known || detected != override {
+				log.Warn("EntryPoint version set by ENTRY_POINT_VERSIONS", "entryPoint", ep, "codeHash", hash, "version", override, "detected", detected)
+			}
+			versions[ep] = override
+		case known:
+			versions[ep] = detected
+		default:
+			return nil, fmt.Errorf("EntryPoint %s has unknown code (hash %s), add the hash to ENTRY_POINT_CODE_HASHES or set the version in ENTRY_POINT_VERSIONS", ep, hash)
+		}
+	}
+	return versions, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeCode answers eth_getCode.
+type fakeCode map[common.Address][]byte
+
+func (f fakeCode) GetCode(addr common.Address, block string) hexutil.Bytes {
+	return f[addr]
+}
+
+func TestDetectEntryPointVersions(t *testing.T) {
+	v06 := entryPointV06
+	custom, unknown, empty := common.Address{2}, common.Address{3}, common.Address{4}
+	code := fakeCode{v06: entryPointV06Code(t), custom: {0x60, 0x07}, unknown: {0x60, 0xff}}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", code); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+	conn := ethclient.NewClient(client)
+
+	settings := &chainSettings{}
+	versions, err := detectEntryPointVersions(conn, []common.Address{v06}, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions[v06] != entryPointVersion06 {
+		t.Errorf("canonical v0.6 detected as %q", versions[v06])
+	}
+
+	settings.EntryPointCodeHashes = map[common.Hash]entryPointVersion{crypto.Keccak256Hash(code[custom]): entryPointVersion07}
+	versions, err = detectEntryPointVersions(conn, []common.Address{v06, custom}, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions[v06] != entryPointVersion06 || versions[custom] != entryPointVersion07 {
+		t.Errorf("ENTRY_POINT_CODE_HASHES not applied: %v", versions)
+	}
+
+	if _, err := detectEntryPointVersions(conn, []common.Address{unknown}, settings); err == nil {
+		t.Error("accepted an EntryPoint with unknown code")
+	}
+	if _, err := detectEntryPointVersions(conn, []common.Address{empty}, settings); err == nil {
+		t.Error("accepted an EntryPoint without code")
+	}
+
+	settings.EntryPointVersions = map[common.Address]entryPointVersion{unknown: entryPointVersion07, v06: entryPointVersion07}
+	versions, err = detectEntryPointVersions(conn, []common.Address{unknown, v06}, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions[unknown] != entryPointVersion07 || versions[v06] != entryPointVersion07 {
+		t.Errorf("ENTRY_POINT_VERSIONS not applied: %v", versions)
+	}
+}
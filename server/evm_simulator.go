@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Simulators selectable with SIMULATOR.
+const (
+	// simulatorNode runs simulateValidation on the node with eth_call and
+	// debug_traceCall.
+	simulatorNode = "node"
+	// simulatorEVM runs simulateValidation in process over state fetched
+	// from the node.
+	simulatorEVM = "evm"
+)
+
+// simulateAndTrace simulates op with the configured simulator and returns its
+// result and the trace the validation rules are checked on. v0.7 EntryPoints
+// are always simulated in process, see simulateValidationV07.
+func simulateAndTrace(config *params.ChainConfig, ep common.Address, version entryPointVersion, heads *headCache, states *nodeStateCache, client *rpc.Client, op _UserOperation) (*simulationResult, *validationTrace, error) {
+	if version == entryPointVersion07 || (conf != nil && conf.Simulator == simulatorEVM) {
+		header, err := heads.latest()
+		if err != nil {
+			return nil, nil, err
+		}
+		src := states.at(client, header)
+		return simulateInProcess(config, ep, version, src, header, op, nil)
+	}
+	sim, err := callSimulateValidation(client, ep, version, op, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	trace, err := op.traceValidation(client, ep, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sim, trace, nil
+}
+
+// simulateInProcess runs simulateValidation of the EntryPoint ep of version in
+// the EVM of a chain with config on top of src with overrides applied, at the
+// block of header.
+func simulateInProcess(config *params.ChainConfig, ep common.Address, version entryPointVersion, src stateSource, header *types.Header, op _UserOperation, overrides stateOverrides) (*simulationResult, *validationTrace, error) {
+	statedb := newRemoteState(src)
+	statedb.applyOverrides(overrides)
+	logger := newValidationLogger()
+	evm := newSimulationEVM(config, src, header, statedb, logger)
+	if version == entryPointVersion07 {
+		sim, err := simulateValidationV07(evm, logger, ep, op)
+		if statedb.err != nil {
+			return nil, nil, statedb.err
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return sim, logger.trace(), nil
+	}
+
+	data, err := packSimulateValidation(version, op)
+	if err != nil {
+		return nil, nil, err
+	}
+	statedb.PrepareAccessList(zeroAddress, &ep, vm.ActivePrecompiles(evm.ChainConfig().Rules(evm.Context.BlockNumber, evm.Context.Random != nil)), nil)
+	ret, _, err := evm.Call(vm.AccountRef(zeroAddress), ep, data, simulationGasLimit, new(big.Int))
+	if statedb.err != nil {
+		return nil, nil, statedb.err
+	}
+	if err == nil {
+		return nil, nil, fmt.Errorf("simulateValidation of %s returned %s instead of reverting", ep, hexutil.Bytes(ret))
+	}
+	if !errors.Is(err, vm.ErrExecutionReverted) {
+		return nil, nil, &simulationRejection{Revert: &revertReason{Reason: err.Error()}}
+	}
+	sim, err := decodeSimulationResult(ret)
+	if err == errSimulationReverted {
+		return nil, nil, newSimulationRejection(ret)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return sim, logger.trace(), nil
+}
+
+// newSimulationEVM returns an EVM of a chain with config over statedb at the
+// block of header, whose ancestors are looked up through src. It traces with
+// logger, if not nil, and charges no fees.
+func newSimulationEVM(config *params.ChainConfig, src stateSource, header *types.Header, statedb *remoteState, logger vm.EVMLogger) *vm.EVM {
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     blockHashes(src, header),
+		Coinbase:    header.Coinbase,
+		GasLimit:    header.GasLimit,
+		BlockNumber: new(big.Int).Set(header.Number),
+		Time:        new(big.Int).SetUint64(header.Time),
+		Difficulty:  header.Difficulty,
+		BaseFee:     header.BaseFee,
+	}
+	if header.Difficulty == nil || header.Difficulty.Sign() == 0 {
+		random := header.MixDigest
+		blockCtx.Random = &random
+	}
+	return vm.NewEVM(blockCtx, vm.TxContext{Origin: zeroAddress, GasPrice: new(big.Int)}, statedb, config,
+		vm.Config{Debug: logger != nil, Tracer: logger, NoBaseFee: true})
+}
+
+// blockHashes returns a BLOCKHASH lookup that fetches the ancestors of header
+// from the node src reads from, if any. BLOCKHASH is banned during validation,
+// so this is rarely called.
+func blockHashes(src stateSource, header *types.Header) vm.GetHashFunc {
+	return func(n uint64) common.Hash {
+		if n >= header.Number.Uint64() {
+			return common.Hash{}
+		}
+		if n+1 == header.Number.Uint64() {
+			return header.ParentHash
+		}
+		node, ok := src.(*nodeState)
+		if !ok {
+			return common.Hash{}
+		}
+		h, err := ethclient.NewClient(node.client).HeaderByNumber(context.Background(), new(big.Int).SetUint64(n))
+		if err != nil {
+			return common.Hash{}
+		}
+		return h.Hash()
+	}
+}
+
+// validationLogger is the native equivalent of validationTracer.
+type validationLogger struct {
+	frames []traceFrame
+	open   []int
+	lastOp []string
+	keccak []hexutil.Bytes
+}
+
+func newValidationLogger() *validationLogger {
+	return &validationLogger{}
+}
+
+// enter opens a frame for the EntryPoint ep, for calls it makes from Go rather
+// than through its code. The frames of the call are attributed as if ep made
+// it.
+func (l *validationLogger) enter(ep common.Address) {
+	l.frames = append(l.frames, traceFrame{
+		Address: ep,
+		Parent:  -1,
+		Opcodes: map[string]int{},
+		Reads:   map[common.Hash]int{},
+		Writes:  map[common.Hash]int{},
+	})
+	l.lastOp = append(l.lastOp, "")
+	l.open = []int{len(l.frames) - 1}
+}
+
+func (l *validationLogger) trace() *validationTrace {
+	return &validationTrace{Frames: l.frames, Keccak: l.keccak}
+}
+
+func (l *validationLogger) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	for len(l.open) > 0 && l.frames[l.open[len(l.open)-1]].Depth > depth {
+		l.open = l.open[:len(l.open)-1]
+	}
+	top := -1
+	if len(l.open) > 0 {
+		top = l.open[len(l.open)-1]
+	}
+	if top < 0 || l.frames[top].Depth < depth {
+		l.frames = append(l.frames, traceFrame{
+			Address: scope.Contract.Address(),
+			Depth:   depth,
+			Parent:  top,
+			Opcodes: map[string]int{},
+			Reads:   map[common.Hash]int{},
+			Writes:  map[common.Hash]int{},
+		})
+		l.lastOp = append(l.lastOp, "")
+		top = len(l.frames) - 1
+		l.open = append(l.open, top)
+	}
+	frame := &l.frames[top]
+	name := op.String()
+	if l.lastOp[top] == "GAS" && !isCallOp(op) {
+		frame.Opcodes["GAS"]++
+	}
+	if op != vm.GAS {
+		frame.Opcodes[name]++
+	}
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		slot := common.Hash(scope.Stack.Back(0).Bytes32())
+		if op == vm.SLOAD {
+			frame.Reads[slot]++
+		} else {
+			frame.Writes[slot]++
+		}
+	case vm.KECCAK256:
+		offset, size := scope.Stack.Back(0), scope.Stack.Back(1)
+		if offset.IsUint64() && size.IsUint64() && size.Uint64() > 0 && size.Uint64() <= 512 &&
+			offset.Uint64()+size.Uint64() <= uint64(scope.Memory.Len()) {
+			l.keccak = append(l.keccak, scope.Memory.GetCopy(int64(offset.Uint64()), int64(size.Uint64())))
+		}
+	}
+	l.lastOp[top] = name
+}
+
+func isCallOp(op vm.OpCode) bool {
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		return true
+	}
+	return false
+}
+
+func (l *validationLogger) CaptureTxStart(gasLimit uint64) {}
+
+func (l *validationLogger) CaptureTxEnd(restGas uint64) {}
+
+func (l *validationLogger) CaptureStart(env *vm.EVM, from, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (l *validationLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) {}
+
+func (l *validationLogger) CaptureEnter(typ vm.OpCode, from, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (l *validationLogger) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (l *validationLogger) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// mapSource is a stateSource that counts how often it is read.
+type mapSource struct {
+	accounts map[common.Address]*remoteAccount
+	storage  map[common.Address]map[common.Hash]common.Hash
+	reads    int
+}
+
+func (m *mapSource) Account(addr common.Address) (*remoteAccount, error) {
+	m.reads++
+	if acct, ok := m.accounts[addr]; ok {
+		return acct, nil
+	}
+	return &remoteAccount{Balance: new(big.Int)}, nil
+}
+
+func (m *mapSource) Storage(addr common.Address, slot common.Hash) (common.Hash, error) {
+	m.reads++
+	return m.storage[addr][slot], nil
+}
+
+func TestInProcessSimulation(t *testing.T) {
+	account := common.HexToAddress("0xaa")
+	// SLOAD(1), SSTORE(2, 1), KECCAK256 of the first word of memory
+	accountCode := []byte{
+		byte(vm.PUSH1), 1, byte(vm.SLOAD),
+		byte(vm.PUSH1), 2, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0xaa, byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.KECCAK256), byte(vm.POP),
+		byte(vm.TIMESTAMP), byte(vm.POP), byte(vm.STOP),
+	}
+	entryPoint := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0xaa, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP),
+	}
+	slot1, value := common.BigToHash(big.NewInt(1)), common.HexToHash("0x1234")
+
+	// the JS tracer on a local state, as debug_traceCall would run it
+	sdb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	sdb.SetCode(account, accountCode)
+	sdb.SetState(account, slot1, value)
+	want := runValidationTracer(t, sdb, entryPoint)
+
+	// the native logger on the same state served remotely
+	epAddr := common.HexToAddress("0xe9")
+	src := &mapSource{
+		accounts: map[common.Address]*remoteAccount{
+			account: {Balance: new(big.Int), Code: accountCode},
+			epAddr:  {Balance: new(big.Int), Code: entryPoint},
+		},
+		storage: map[common.Address]map[common.Hash]common.Hash{account: {slot1: value}},
+	}
+	statedb := newRemoteState(src)
+	logger := newValidationLogger()
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(0),
+		Difficulty:  new(big.Int),
+		BaseFee:     new(big.Int),
+		GasLimit:    1e7,
+	}
+	evm := vm.NewEVM(blockCtx, vm.TxContext{}, statedb, params.AllEthashProtocolChanges, vm.Config{Debug: true, Tracer: logger})
+	if _, _, err := evm.Call(vm.AccountRef(zeroAddress), epAddr, nil, 1e6, new(big.Int)); err != nil {
+		t.Fatal(err)
+	}
+	if statedb.err != nil {
+		t.Fatal(statedb.err)
+	}
+	got := logger.trace()
+	// the JS tracer runs the entry point code from a different address
+	got.Frames[0].Address = want.Frames[0].Address
+	if !reflect.DeepEqual(got.Frames, want.Frames) {
+		t.Errorf("native trace %+v, JS trace %+v", got.Frames, want.Frames)
+	}
+	if len(got.Keccak) != 1 || !reflect.DeepEqual(got.Keccak, want.Keccak) {
+		t.Errorf("native keccak %v, JS keccak %v", got.Keccak, want.Keccak)
+	}
+
+	// the write is visible, the read was served by the source once, and a
+	// revert undoes the write
+	snap := statedb.Snapshot()
+	statedb.SetState(account, slot1, common.Hash{})
+	statedb.RevertToSnapshot(snap)
+	if statedb.GetState(account, slot1) != value {
+		t.Errorf("revert did not restore slot 1")
+	}
+	if statedb.GetState(account, common.BigToHash(big.NewInt(2))) != value {
+		t.Errorf("SSTORE of slot 2 is lost")
+	}
+	reads := src.reads
+	statedb.GetState(account, slot1)
+	if src.reads != reads {
+		t.Errorf("slot 1 was fetched again")
+	}
+}
+
+// entryPointV06Code returns the runtime code of entryPointV06.
+func entryPointV06Code(t *testing.T) []byte {
+	t.Helper()
+	hex, err := os.ReadFile("testdata/entrypoint_v06.hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return common.FromHex(strings.TrimSpace(string(hex)))
+}
+
+// entryPointV07Code returns the runtime code of the canonical EntryPoint v0.7,
+// whose SenderCreator address is built in.
+func entryPointV07Code(t *testing.T) []byte {
+	t.Helper()
+	hex, err := os.ReadFile("testdata/entrypoint_v07.hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return common.FromHex(strings.TrimSpace(string(hex)))
+}
+
+// returnWordCode returns code that returns word, e.g. the validationData of
+// an account that accepts every op.
+func returnWordCode(word common.Hash) []byte {
+	return returnWordsCode(word)
+}
+
+// returnWordsCode returns code that returns words.
+func returnWordsCode(words ...common.Hash) []byte {
+	var code []byte
+	for i, word := range words {
+		code = append(code, byte(vm.PUSH32))
+		code = append(code, word.Bytes()...)
+		code = append(code, byte(vm.PUSH1), byte(32*i), byte(vm.MSTORE))
+	}
+	return append(code, byte(vm.PUSH1), byte(32*len(words)), byte(vm.PUSH1), 0, byte(vm.RETURN))
+}
+
+func TestSimulateEntryPointV06(t *testing.T) {
+	account := common.HexToAddress("0xaa")
+	// validAfter 1000 and validUntil 2000, no aggregator
+	validationData := new(big.Int).Lsh(big.NewInt(1000), 208)
+	validationData.Or(validationData, new(big.Int).Lsh(big.NewInt(2000), 160))
+	src := &mapSource{accounts: map[common.Address]*remoteAccount{
+		entryPointV06: {Balance: new(big.Int), Code: entryPointV06Code(t)},
+		account:       {Balance: new(big.Int), Code: returnWordCode(common.BigToHash(validationData))},
+	}}
+	// no fees, so no prefund is needed
+	op := _UserOperation{
+		Sender:               account,
+		Nonce:                new(big.Int),
+		CallGasLimit:         big.NewInt(10000),
+		VerificationGasLimit: big.NewInt(100000),
+		PreVerificationGas:   big.NewInt(50000),
+		MaxFeePerGas:         new(big.Int),
+		MaxPriorityFeePerGas: new(big.Int),
+	}
+	sim, trace, err := simulateInProcess(params.AllEthashProtocolChanges, entryPointV06, entryPointVersion06, src, testHeader(1, 0), op, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Window != (validityWindow{ValidAfter: 1000, ValidUntil: 2000}) || sim.SigFailed || sim.PreOpGas.Sign() == 0 {
+		t.Errorf("unexpected ValidationResult %+v", sim)
+	}
+	if len(trace.Frames) < 2 || trace.Frames[0].Address != entryPointV06 {
+		t.Fatalf("unexpected trace %+v", trace.Frames)
+	}
+
+	// an account that reverts is rejected with the EntryPoint's FailedOp
+	src.accounts[account].Code = []byte{byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.REVERT)}
+	_, _, err = simulateInProcess(params.AllEthashProtocolChanges, entryPointV06, entryPointVersion06, src, testHeader(1, 0), op, nil)
+	rejection, ok := err.(*simulationRejection)
+	if !ok || rejection.Revert.Error != "FailedOp" || !strings.HasPrefix(rejection.Revert.Reason, "AA23") {
+		t.Fatalf("reverting account: got %v, want FailedOp AA23", err)
+	}
+}
+
+// evmNode answers eth_call by running the call in process over src, the way
+// a node would.
+type evmNode struct {
+	src stateSource
+}
+
+type callArgs struct {
+	From common.Address  `json:"from"`
+	To   common.Address  `json:"to"`
+	Gas  *hexutil.Uint64 `json:"gas"`
+	Data hexutil.Bytes   `json:"data"`
+}
+
+func (n evmNode) Call(args callArgs, block string, overrides *stateOverrides) (hexutil.Bytes, error) {
+	statedb := newRemoteState(n.src)
+	if overrides != nil {
+		statedb.applyOverrides(*overrides)
+	}
+	header := testHeader(1, 0)
+	random := header.MixDigest
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		BlockNumber: header.Number,
+		Time:        new(big.Int),
+		Difficulty:  new(big.Int),
+		BaseFee:     header.BaseFee,
+		GasLimit:    header.GasLimit,
+		Random:      &random,
+	}
+	evm := vm.NewEVM(blockCtx, vm.TxContext{}, statedb, params.AllEthashProtocolChanges, vm.Config{NoBaseFee: true})
+	gas := uint64(simulationGasLimit)
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	}
+	ret, _, err := evm.Call(vm.AccountRef(args.From), args.To, args.Data, gas, new(big.Int))
+	if errors.Is(err, vm.ErrExecutionReverted) {
+		return nil, dataError{ret}
+	}
+	return ret, err
+}
+
+func TestValidateOpOnEntryPointV06(t *testing.T) {
+	account := common.HexToAddress("0xaa")
+	// reads its own slot 0 and returns validationData 0
+	accountCode := append([]byte{byte(vm.PUSH1), 0, byte(vm.SLOAD), byte(vm.POP)}, returnWordCode(common.Hash{})...)
+	src := &mapSource{accounts: map[common.Address]*remoteAccount{
+		entryPointV06: {Balance: new(big.Int), Code: entryPointV06Code(t)},
+		account:       {Balance: new(big.Int), Code: accountCode},
+	}}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", evmNode{src}); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	op := _UserOperation{
+		Sender:               account,
+		Nonce:                new(big.Int),
+		CallData:             []byte{1, 2, 3},
+		CallGasLimit:         big.NewInt(10000),
+		VerificationGasLimit: big.NewInt(100000),
+		PreVerificationGas:   big.NewInt(50000),
+		MaxFeePerGas:         new(big.Int),
+		MaxPriorityFeePerGas: new(big.Int),
+		Signature:            []byte{0xff},
+	}
+	entities, err := parseEntities(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the EntryPoint hashes the packed op with its address and the chain ID
+	hash, err := getUserOpHash(ethclient.NewClient(client), entryPointV06, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	word := func(v *big.Int) []byte { return common.BigToHash(v).Bytes() }
+	packed := crypto.Keccak256(
+		common.LeftPadBytes(op.Sender.Bytes(), 32), word(op.Nonce),
+		crypto.Keccak256(op.InitCode), crypto.Keccak256(op.CallData),
+		word(op.CallGasLimit), word(op.VerificationGasLimit), word(op.PreVerificationGas),
+		word(op.MaxFeePerGas), word(op.MaxPriorityFeePerGas), crypto.Keccak256(op.PaymasterAndData),
+	)
+	want := crypto.Keccak256Hash(packed, common.LeftPadBytes(entryPointV06.Bytes(), 32), word(params.AllEthashProtocolChanges.ChainID))
+	if hash != want {
+		t.Errorf("userOpHash %s, want %s", hash, want)
+	}
+
+	// simulateValidation through the node and in process agree
+	sim, err := callSimulateValidation(client, entryPointV06, entryPointVersion06, op, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inProcess, trace, err := simulateInProcess(params.AllEthashProtocolChanges, entryPointV06, entryPointVersion06, src, testHeader(1, 0), op, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.PreOpGas.Cmp(inProcess.PreOpGas) != 0 || sim.Window != inProcess.Window || sim.SigFailed {
+		t.Errorf("node result %+v, in-process result %+v", sim, inProcess)
+	}
+	if rpcErr := checkSimulationResult(sim); rpcErr != nil {
+		t.Errorf("simulation result rejected: %v", rpcErr.Message)
+	}
+
+	// the EntryPoint itself is not held to the rules, the account only reads
+	// its own storage
+	if rpcErr := checkOpcodeRules(trace, entities); rpcErr != nil {
+		t.Errorf("opcode rules: %v", rpcErr.Message)
+	}
+	if rpcErr, reqs := checkStorageRules(trace, entities); rpcErr != nil || len(reqs) != 0 {
+		t.Errorf("storage rules: %v, stake requirements %+v", rpcErr, reqs)
+	}
+}
+
+func TestSimulateEntryPointV07(t *testing.T) {
+	ep := common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032")
+	account, paymaster := common.HexToAddress("0xaa"), common.HexToAddress("0xbb")
+	code := entryPointV07Code(t)
+	if version := knownEntryPointCode[crypto.Keccak256Hash(code)]; version != entryPointVersion07 {
+		t.Fatalf("testdata code is %q, not the canonical v0.7", version)
+	}
+	// validAfter 1000 and validUntil 2000 for the account, validUntil 1500
+	// for the paymaster
+	window := func(after, until int64) common.Hash {
+		data := new(big.Int).Lsh(big.NewInt(after), 208)
+		return common.BigToHash(data.Or(data, new(big.Int).Lsh(big.NewInt(until), 160)))
+	}
+	src := &mapSource{accounts: map[common.Address]*remoteAccount{
+		ep:      {Balance: new(big.Int), Code: code},
+		account: {Balance: new(big.Int), Code: append([]byte{byte(vm.PUSH1), 0, byte(vm.SLOAD), byte(vm.POP)}, returnWordCode(window(1000, 2000))...)},
+		// returns an empty context and its validationData
+		paymaster: {Balance: new(big.Int), Code: returnWordsCode(common.BigToHash(big.NewInt(64)), window(0, 1500), common.Hash{})},
+	}}
+	// no fees, so no prefund is needed
+	op := _UserOperation{
+		Sender:               account,
+		Nonce:                new(big.Int),
+		CallGasLimit:         big.NewInt(10000),
+		VerificationGasLimit: big.NewInt(100000),
+		PreVerificationGas:   big.NewInt(50000),
+		MaxFeePerGas:         new(big.Int),
+		MaxPriorityFeePerGas: new(big.Int),
+	}
+	config, header := params.AllEthashProtocolChanges, testHeader(1, 0)
+	sim, trace, err := simulateInProcess(config, ep, entryPointVersion07, src, header, op, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Window != (validityWindow{ValidAfter: 1000, ValidUntil: 2000}) || sim.SigFailed || sim.PreOpGas.Cmp(op.PreVerificationGas) <= 0 {
+		t.Errorf("unexpected result %+v", sim)
+	}
+	entities := opEntities(op)
+	if rpcErr := checkOpcodeRules(trace, entities); rpcErr != nil {
+		t.Errorf("opcode rules: %v", rpcErr.Message)
+	}
+	if rpcErr, reqs := checkStorageRules(trace, entities); rpcErr != nil || len(reqs) != 0 {
+		t.Errorf("storage rules: %v, stake requirements %+v", rpcErr, reqs)
+	}
+	accountFrames := 0
+	for i := range trace.Frames {
+		if trace.entityOf(i, entities) == entityAccount {
+			accountFrames++
+		}
+	}
+	if accountFrames != 1 {
+		t.Errorf("%d frames attributed to the account, want 1", accountFrames)
+	}
+
+	// the paymaster narrows the window
+	withPaymaster := op
+	withPaymaster.PaymasterAndData = paymaster.Bytes()
+	withPaymaster.PaymasterVerificationGasLimit = big.NewInt(100000)
+	withPaymaster.PaymasterPostOpGasLimit = new(big.Int)
+	sim, _, err = simulateInProcess(config, ep, entryPointVersion07, src, header, withPaymaster, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Window != (validityWindow{ValidAfter: 1000, ValidUntil: 1500}) {
+		t.Errorf("window with paymaster %+v, want {1000 1500}", sim.Window)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		op        func(op _UserOperation) _UserOperation
+		account   []byte
+		errorName string
+		reason    string
+	}{
+		{
+			name:      "reverting account",
+			op:        func(op _UserOperation) _UserOperation { return op },
+			account:   []byte{byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.REVERT)},
+			errorName: "FailedOpWithRevert",
+			reason:    "AA23 reverted",
+		},
+		{
+			name:      "signature failure is a result",
+			op:        func(op _UserOperation) _UserOperation { return op },
+			account:   returnWordCode(common.BigToHash(big.NewInt(1))),
+			errorName: "",
+		},
+		{
+			name: "nonce",
+			op: func(op _UserOperation) _UserOperation {
+				op.Nonce = big.NewInt(1)
+				return op
+			},
+			errorName: "FailedOp",
+			reason:    "AA25 invalid account nonce",
+		},
+		{
+			name: "undeployed account",
+			op: func(op _UserOperation) _UserOperation {
+				op.Sender = common.HexToAddress("0xcc")
+				return op
+			},
+			errorName: "FailedOp",
+			reason:    "AA20 account not deployed",
+		},
+	} {
+		accountCode := src.accounts[account].Code
+		if tt.account != nil {
+			src.accounts[account].Code = tt.account
+		}
+		sim, _, err := simulateInProcess(config, ep, entryPointVersion07, src, header, tt.op(op), nil)
+		src.accounts[account].Code = accountCode
+		if tt.errorName == "" {
+			if err != nil || !sim.SigFailed {
+				t.Errorf("%s: got %+v, %v, want a result with SigFailed", tt.name, sim, err)
+			}
+			continue
+		}
+		rejection, ok := err.(*simulationRejection)
+		if !ok || rejection.Revert.Error != tt.errorName || rejection.Revert.Reason != tt.reason {
+			t.Errorf("%s: got %v, want %s %q", tt.name, err, tt.errorName, tt.reason)
+		}
+	}
+}
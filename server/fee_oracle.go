@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// feePercentiles are the eth_feeHistory reward percentiles the slow, standard
+// and fast tiers are built on.
+var feePercentiles = []float64{25, 50, 75}
+
+// feeBaseFeePercent is, per tier, the percentage of the next base fee its
+// maxFeePerGas leaves room for, so that the op stays valid while the base fee
+// rises.
+var feeBaseFeePercent = []int64{125, 150, 200}
+
+type GasPriceRequest struct {
+	Jsonrpc string            `json:"jsonrpc"`
+	Id      *big.Int          `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// GasPrice is the fee fields of an op.
+type GasPrice struct {
+	MaxFeePerGas         *hexutil.Big `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big `json:"maxPriorityFeePerGas"`
+}
+
+// GasPriceQuote is the result of bundler_getUserOperationGasPrice.
+type GasPriceQuote struct {
+	Slow     GasPrice `json:"slow"`
+	Standard GasPrice `json:"standard"`
+	Fast     GasPrice `json:"fast"`
+}
+
+// feeOracle recommends op fees from the priority fees paid in recent blocks,
+// read with eth_feeHistory, plus the bundler's margin. Quotes are computed
+// once per head, along with the admission floor: the slow tip without the
+// margin.
+type feeOracle struct {
+	conn   *ethclient.Client
+	heads  *headCache
+	blocks uint64
+	margin uint64
+	// minTip is the priority fee floor of the chain profile; no tier is
+	// below it.
+	minTip *big.Int
+
+	mu    sync.Mutex
+	block common.Hash
+	quote *GasPriceQuote
+	// floor is the admission floor at block, prevFloor the one at the head
+	// before it, if any
+	floor, prevFloor *big.Int
+}
+
+func newFeeOracle(conn *ethclient.Client, heads *headCache, chain *chainProfile, settings *chainSettings) *feeOracle {
+	return &feeOracle{
+		conn:   conn,
+		heads:  heads,
+		blocks: settings.FeeHistoryBlocks,
+		margin: settings.FeeMargin,
+		minTip: chain.MinPriorityFee,
+	}
+}
+
+// gasPrice returns the fee tiers of the next block.
+func (o *feeOracle) gasPrice() (*GasPriceQuote, error) {
+	head, err := o.heads.latest()
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.quote != nil && o.block == head.Hash() {
+		return o.quote, nil
+	}
+	history, err := o.conn.FeeHistory(context.Background(), o.blocks, head.Number, feePercentiles)
+	if err != nil {
+		return nil, err
+	}
+	baseFee, err := o.heads.nextBaseFee()
+	if err != nil {
+		return nil, err
+	}
+	tips := tierTips(history, o.margin, o.minTip)
+	floor := tierTips(history, 0, o.minTip)[0]
+	tiers := make([]GasPrice, len(tips))
+	for i, tip := range tips {
+		maxFee := new(big.Int).Mul(baseFee, big.NewInt(feeBaseFeePercent[i]))
+		maxFee.Div(maxFee, big.NewInt(100))
+		maxFee.Add(maxFee, tip)
+		tiers[i] = GasPrice{MaxFeePerGas: (*hexutil.Big)(maxFee), MaxPriorityFeePerGas: (*hexutil.Big)(tip)}
+	}
+	o.block = head.Hash()
+	o.quote = &GasPriceQuote{Slow: tiers[0], Standard: tiers[1], Fast: tiers[2]}
+	o.prevFloor, o.floor = o.floor, floor
+	return o.quote, nil
+}
+
+// minPriorityFee returns the lowest maxPriorityFeePerGas ops are admitted
+// with: the slow tip before the margin, or the previous head's if lower, so
+// that an op sent with a slow quote is not rejected when the next block
+// raises the fees. Without a fee history the profile's floor applies.
+func (o *feeOracle) minPriorityFee() *big.Int {
+	if _, err := o.gasPrice(); err != nil {
+		log.Warn("failed to get fee history, falling back to the priority fee floor", "error", err)
+		if o.minTip == nil {
+			return new(big.Int)
+		}
+		return o.minTip
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.prevFloor != nil && o.prevFloor.Cmp(o.floor) < 0 {
+		return o.prevFloor
+	}
+	return o.floor
+}
+
+// tierTips returns, for every reward percentile of history, its median over
+// the blocks that had transactions, raised by margin percent and to at least
+// minTip.
+func tierTips(history *ethereum.FeeHistory, margin uint64, minTip *big.Int) []*big.Int {
+	tips := make([]*big.Int, len(feePercentiles))
+	for i := range feePercentiles {
+		var rewards []*big.Int
+		for block, reward := range history.Reward {
+			if i < len(reward) && block < len(history.GasUsedRatio) && history.GasUsedRatio[block] > 0 {
+				rewards = append(rewards, reward[i])
+			}
+		}
+		tip := new(big.Int)
+		if len(rewards) > 0 {
+			sort.Slice(rewards, func(a, b int) bool { return rewards[a].Cmp(rewards[b]) < 0 })
+			tip.Set(rewards[len(rewards)/2])
+		}
+		tip.Mul(tip, new(big.Int).SetUint64(100+margin))
+		tip.Div(tip, big.NewInt(100))
+		if minTip != nil && tip.Cmp(minTip) < 0 {
+			tip.Set(minTip)
+		}
+		tips[i] = tip
+	}
+	return tips
+}
+
+// handle_bundler_getUserOperationGasPrice returns the slow, standard and fast
+// fees recommended for ops sent now. Ops are accepted down to the slow tier's
+// maxPriorityFeePerGas before the margin.
+func (b *bundler) handle_bundler_getUserOperationGasPrice(respw http.ResponseWriter, req *http.Request) {
+	respw.Header().Set("Content-Type", "application/json")
+	respw.WriteHeader(200)
+	var r GasPriceRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quote, err := b.fees.gasPrice()
+	if err != nil {
+		http.Error(respw, "failed to get fee history: "+err.Error(), e.JsonRpcInternalError)
+		return
+	}
+	json.NewEncoder(respw).Encode(NewRPCResult(r.Id, quote))
+}
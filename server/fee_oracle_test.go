@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestTierTips(t *testing.T) {
+	gwei := func(n int64) *big.Int { return big.NewInt(n * 1e9) }
+	history := &ethereum.FeeHistory{
+		Reward: [][]*big.Int{
+			{gwei(1), gwei(2), gwei(3)},
+			{gwei(0), gwei(0), gwei(0)}, // empty block
+			{gwei(2), gwei(4), gwei(6)},
+			{gwei(3), gwei(6), gwei(9)},
+		},
+		GasUsedRatio: []float64{0.4, 0, 0.6, 0.5},
+	}
+	tips := tierTips(history, 10, gwei(1))
+	want := []*big.Int{big.NewInt(2.2e9), big.NewInt(4.4e9), big.NewInt(6.6e9)}
+	for i := range want {
+		if tips[i].Cmp(want[i]) != 0 {
+			t.Errorf("tier %d tip = %v, want %v", i, tips[i], want[i])
+		}
+	}
+
+	// the profile floor applies when blocks are empty
+	tips = tierTips(&ethereum.FeeHistory{Reward: [][]*big.Int{{gwei(0), gwei(0), gwei(0)}}, GasUsedRatio: []float64{0}}, 10, gwei(1))
+	for i, tip := range tips {
+		if tip.Cmp(gwei(1)) != 0 {
+			t.Errorf("tier %d tip = %v over empty blocks, want the 1 gwei floor", i, tip)
+		}
+	}
+}
+
+// fakeFeeHistory answers eth_feeHistory with the same slow, standard and fast
+// rewards for every block, or fails.
+type fakeFeeHistory struct {
+	rewards []*hexutil.Big
+	fail    bool
+}
+
+type feeHistoryResult struct {
+	Reward       [][]*hexutil.Big `json:"reward"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+}
+
+func (f *fakeFeeHistory) FeeHistory(count hexutil.Uint, last string, percentiles []float64) (*feeHistoryResult, error) {
+	if f.fail {
+		return nil, errors.New("fee history unavailable")
+	}
+	return &feeHistoryResult{Reward: [][]*hexutil.Big{f.rewards}, GasUsedRatio: []float64{0.5}}, nil
+}
+
+func TestMinPriorityFee(t *testing.T) {
+	gwei := func(n int64) *big.Int { return big.NewInt(n * 1e9) }
+	fees := &fakeFeeHistory{}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", fees); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+	heads := newHeadCache(nil, chainProfiles["sepolia"], 0)
+	oracle := newFeeOracle(ethclient.NewClient(client), heads, &chainProfile{MinPriorityFee: gwei(1)}, &chainSettings{FeeHistoryBlocks: 1, FeeMargin: 10})
+
+	step := func(block uint64, slow int64, want *big.Int) {
+		t.Helper()
+		fees.rewards = []*hexutil.Big{(*hexutil.Big)(gwei(slow)), (*hexutil.Big)(gwei(slow * 2)), (*hexutil.Big)(gwei(slow * 3))}
+		heads.add(testHeader(block, 0))
+		if got := oracle.minPriorityFee(); got.Cmp(want) != 0 {
+			t.Errorf("block %d: floor = %v, want %v", block, got, want)
+		}
+	}
+	step(10, 2, gwei(2)) // below the 2.2 gwei slow quote
+	step(11, 3, gwei(2)) // the previous head's floor
+	step(12, 3, gwei(3))
+
+	fees.fail = true
+	step(13, 3, gwei(1)) // the profile's floor
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// estimationVerificationGasLimit is the verificationGasLimit ops are simulated
+// with while estimating.
+const estimationVerificationGasLimit = 10_000_000
+
+// dummySignature stands in for a missing signature, so that the calldata cost
+// of a 65 byte ECDSA signature is included in preVerificationGas.
+var dummySignature = bytes.Repeat([]byte{1}, 65)
+
+type GasEstimate struct {
+	PreVerificationGas   *hexutil.Big `json:"preVerificationGas"`
+	VerificationGasLimit *hexutil.Big `json:"verificationGasLimit"`
+	CallGasLimit         *hexutil.Big `json:"callGasLimit"`
+}
+
+// EstimateRequest is an eth_estimateUserOperationGas request. Each param may
+// carry a state override set applied to every simulation of the estimate.
+type EstimateRequest struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	Id      *big.Int         `json:"id"`
+	Method  string           `json:"method"`
+	Params  []EstimateParams `json:"params"`
+}
+
+type EstimateParams struct {
+	UserOperationJSON
+	StateOverride stateOverrides `json:"stateOverride"`
+}
+
+// handle_eth_estimateUserOperationGas estimates the gas fields of an op. Gas
+// fields and fees that are missing are ignored, and a missing signature is
+// replaced by a dummy one; the account must not fail validation on it.
+func (b *bundler) handle_eth_estimateUserOperationGas(respw http.ResponseWriter, req *http.Request) {
+	respw.Header().Set("Content-Type", "application/json")
+	respw.WriteHeader(200)
+	var r EstimateRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.Params) != 1 {
+		http.Error(respw, "invalid number of params for eth_estimateUserOperationGas", e.JsonRpcInvalidParams)
+		return
+	}
+	UopwithEP := NewTypeUserOperation(r.Params[0].UserOperationJSON)
+	if !b.checkSafeEntryPoint(UopwithEP) {
+		http.Error(respw, "Entry point not safe,", e.JsonRpcInvalidParams)
+		return
+	}
+	if err := b.checkOpVersion(UopwithEP.EntryPoint, UopwithEP.UserOperation); err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInvalidParams)
+		return
+	}
+	if _, err := parseEntities(UopwithEP.UserOperation); err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInvalidParams)
+		return
+	}
+	estimate, rpcErr, err := estimateUserOperationGas(b.chain.Config, UopwithEP.EntryPoint, b.chain.version(UopwithEP.EntryPoint), b.heads, b.stateCache,
+		b.nodes.RPC(), UopwithEP.UserOperation, r.Params[0].StateOverride)
+	if err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInternalError)
+		return
+	}
+	if rpcErr != nil {
+		json.NewEncoder(respw).Encode(NewRPCError(r.Id, rpcErr))
+		return
+	}
+	json.NewEncoder(respw).Encode(NewRPCResult(r.Id, estimate))
+}
+
+// estimateUserOperationGas simulates op without fees, so that no prefund is
+// needed, with overrides applied. Ops to v0.7 EntryPoints are simulated in
+// process, like in simulateAndTrace. Reverts are returned as an RPCError.
+func estimateUserOperationGas(config *params.ChainConfig, ep common.Address, version entryPointVersion, heads *headCache, states *nodeStateCache, client *rpc.Client, op _UserOperation, overrides stateOverrides) (*GasEstimate, *RPCError, error) {
+	op = withEstimationDefaults(op)
+	pvg, err := estimatePreVerificationGas(version, op)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sim *simulationResult
+	if version == entryPointVersion07 {
+		header, err := heads.latest()
+		if err != nil {
+			return nil, nil, err
+		}
+		sim, _, err = simulateInProcess(config, ep, version, states.at(client, header), header, op, overrides)
+	} else {
+		sim, err = callSimulateValidation(client, ep, version, op, overrides)
+	}
+	var rejection *simulationRejection
+	if errors.As(err, &rejection) {
+		return nil, rejection.rpcError(), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// preOpGas includes the preVerificationGas of the simulated op
+	verificationGas := new(big.Int).Sub(sim.PreOpGas, op.PreVerificationGas)
+
+	if len(op.InitCode) > 0 {
+		header, err := heads.latest()
+		if err != nil {
+			return nil, nil, err
+		}
+		var rpcErr *RPCError
+		overrides, rpcErr, err = withDeployedSender(config, ep, states.at(client, header), header, op, overrides)
+		if rpcErr != nil || err != nil {
+			return nil, rpcErr, err
+		}
+	}
+	callGas, rpcErr, err := estimateCallGas(client, ep, op, overrides)
+	if rpcErr != nil || err != nil {
+		return nil, rpcErr, err
+	}
+	return &GasEstimate{
+		PreVerificationGas:   (*hexutil.Big)(new(big.Int).SetUint64(pvg)),
+		VerificationGasLimit: (*hexutil.Big)(verificationGas),
+		CallGasLimit:         (*hexutil.Big)(new(big.Int).SetUint64(callGas)),
+	}, nil, nil
+}
+
+// withDeployedSender returns overrides plus the sender of op as its initCode
+// deploys it, run in process over src through the EntryPoint's SenderCreator.
+// The callData of an op is only executed once its sender is deployed, which
+// eth_call cannot do on its own.
+func withDeployedSender(config *params.ChainConfig, ep common.Address, src stateSource, header *types.Header, op _UserOperation, overrides stateOverrides) (stateOverrides, *RPCError, error) {
+	statedb := newRemoteState(src)
+	statedb.applyOverrides(overrides)
+	evm := newSimulationEVM(config, src, header, statedb, nil)
+	sender, _, err := createSender(evm, ep, op.InitCode, simulationGasLimit)
+	if statedb.err != nil {
+		return nil, nil, statedb.err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if sender != op.Sender || statedb.GetCodeSize(sender) == 0 {
+		return nil, &RPCError{
+			Code:    e.JsonRpcRejectedByEntryPointOrAccount,
+			Message: fmt.Sprintf("initCode does not deploy sender %s", op.Sender),
+		}, nil
+	}
+	deployed := stateOverrides{}
+	for addr, o := range overrides {
+		deployed[addr] = o
+	}
+	deployed[op.Sender] = statedb.override(op.Sender)
+	return deployed, nil, nil
+}
+
+// callGasTolerance is how close, in percent, estimateCallGas gets to the
+// lowest gas limit the call succeeds with.
+const callGasTolerance = 1
+
+// estimateCallGas finds the gas the EntryPoint's call of the op's callData
+// needs by bisecting with eth_call, which unlike eth_estimateGas accepts
+// state overrides. Those must deploy the sender of ops with initCode, see
+// withDeployedSender.
+func estimateCallGas(client *rpc.Client, ep common.Address, op _UserOperation, overrides stateOverrides) (uint64, *RPCError, error) {
+	msg := ethereum.CallMsg{From: ep, To: &op.Sender, Data: op.CallData}
+	call := func(gas uint64) (bool, error) {
+		msg.Gas = gas
+		_, err := callContract(client, msg, overrides)
+		var rpcErr rpc.Error
+		if err != nil && !errors.As(err, &rpcErr) {
+			return false, err
+		}
+		return err == nil, nil
+	}
+
+	hi := uint64(simulationGasLimit)
+	msg.Gas = hi
+	if _, err := callContract(client, msg, overrides); err != nil {
+		var rpcErr rpc.Error
+		if !errors.As(err, &rpcErr) {
+			return 0, nil, err
+		}
+		return 0, revertError(e.JsonRpcRejectedByEntryPointOrAccount, "callData", err), nil
+	}
+	lo := params.TxGas - 1
+	for hi-lo > 1 && (hi-lo)*100 > hi*callGasTolerance {
+		mid := lo + (hi-lo)/2
+		ok, err := call(mid)
+		if err != nil {
+			return 0, nil, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, nil, nil
+}
+
+// estimatePreVerificationGas returns the lowest preVerificationGas that covers
+// the overheads of op to an EntryPoint of version once the field itself is
+// set to it.
+func estimatePreVerificationGas(version entryPointVersion, op _UserOperation) (uint64, error) {
+	pvg := uint64(0)
+	for {
+		op.PreVerificationGas = new(big.Int).SetUint64(pvg)
+		required, err := overheads().preVerificationGas(version, op)
+		if err != nil {
+			return 0, err
+		}
+		if required <= pvg {
+			return pvg, nil
+		}
+		pvg = required
+	}
+}
+
+// withEstimationDefaults fills in what estimation needs and clears the fees.
+func withEstimationDefaults(op _UserOperation) _UserOperation {
+	if len(op.Signature) == 0 {
+		op.Signature = dummySignature
+	}
+	if op.Nonce == nil {
+		op.Nonce = new(big.Int)
+	}
+	if op.CallGasLimit == nil {
+		op.CallGasLimit = new(big.Int)
+	}
+	if op.VerificationGasLimit == nil || op.VerificationGasLimit.Sign() == 0 {
+		op.VerificationGasLimit = big.NewInt(estimationVerificationGasLimit)
+	}
+	// only set for v0.7 ops with a paymaster
+	if op.PaymasterVerificationGasLimit != nil && op.PaymasterVerificationGasLimit.Sign() == 0 {
+		op.PaymasterVerificationGasLimit = big.NewInt(estimationVerificationGasLimit)
+	}
+	if op.PreVerificationGas == nil {
+		op.PreVerificationGas = new(big.Int)
+	}
+	op.MaxFeePerGas = new(big.Int)
+	op.MaxPriorityFeePerGas = new(big.Int)
+	return op
+}
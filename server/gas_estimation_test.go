@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeEth answers eth_call like a node on which the sender's call needs
+// minGas and only succeeds if the sender's balance is overridden.
+type fakeEth struct {
+	sender common.Address
+	minGas uint64
+	calls  int
+}
+
+func (f *fakeEth) Call(args map[string]interface{}, block string, overrides *stateOverrides) (hexutil.Bytes, error) {
+	f.calls++
+	if overrides == nil || (*overrides)[f.sender].Balance == nil {
+		return nil, errors.New("sender is not funded")
+	}
+	if (*overrides)[f.sender].Code != nil {
+		return nil, errors.New("code is overridden although it was not set")
+	}
+	gas, err := hexutil.DecodeUint64(args["gas"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if gas < f.minGas {
+		return nil, errors.New("out of gas")
+	}
+	return nil, nil
+}
+
+func TestEstimateCallGasWithOverrides(t *testing.T) {
+	sender := common.HexToAddress("0xa1")
+	eth := &fakeEth{sender: sender, minGas: 123456}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", eth); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+	op := _UserOperation{Sender: sender, CallData: []byte{1}}
+
+	if _, rpcErr, err := estimateCallGas(client, entryPointV06, op, nil); err != nil || rpcErr == nil {
+		t.Fatalf("estimate without overrides: got %v, %v, want a rejection", rpcErr, err)
+	}
+	overrides := stateOverrides{sender: {Balance: (*hexutil.Big)(big.NewInt(1e18))}}
+	gas, rpcErr, err := estimateCallGas(client, entryPointV06, op, overrides)
+	if err != nil || rpcErr != nil {
+		t.Fatalf("estimate with overrides: %v, %v", rpcErr, err)
+	}
+	if gas < eth.minGas || gas > eth.minGas+eth.minGas*callGasTolerance/100 {
+		t.Fatalf("estimated %d gas, want within %d%% above %d", gas, callGasTolerance, eth.minGas)
+	}
+	if eth.calls > 40 {
+		t.Fatalf("estimate took %d calls", eth.calls)
+	}
+}
+
+func TestEstimateCallGasWithInitCode(t *testing.T) {
+	// the account's constructor sets slot 0, and its code reverts unless
+	// slot 0 is set, then sets slot 1
+	runtime := []byte{
+		byte(vm.PUSH1), 0, byte(vm.SLOAD), byte(vm.ISZERO), byte(vm.PUSH1), 17, byte(vm.JUMPI),
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 1, byte(vm.SSTORE),
+		byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN),
+		byte(vm.JUMPDEST), byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.REVERT),
+	}
+	constructor := []byte{
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE),
+		byte(vm.PUSH1), byte(len(runtime)), byte(vm.DUP1), byte(vm.PUSH1), 16, byte(vm.PUSH1), 0, byte(vm.CODECOPY),
+		byte(vm.PUSH1), 0, byte(vm.RETURN),
+	}
+	constructor = append(constructor, runtime...)
+	// stands in for the SenderCreator and the factory: deploys the account
+	// with CREATE2 and returns its address
+	creator := []byte{
+		byte(vm.PUSH1), byte(len(constructor)), byte(vm.PUSH1), 24, byte(vm.PUSH1), 0, byte(vm.CODECOPY),
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), byte(len(constructor)), byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.CREATE2),
+		byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	}
+	creator = append(creator, constructor...)
+	senderCreator := crypto.CreateAddress(entryPointV06, 1)
+	sender := crypto.CreateAddress2(senderCreator, common.Hash{}, crypto.Keccak256(constructor))
+
+	src := &mapSource{accounts: map[common.Address]*remoteAccount{
+		senderCreator: {Balance: new(big.Int), Code: creator},
+	}}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", evmNode{src}); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+	op := _UserOperation{Sender: sender, InitCode: common.HexToAddress("0xfa").Bytes(), CallData: []byte{1}}
+
+	overrides, rpcErr, err := withDeployedSender(params.AllEthashProtocolChanges, entryPointV06, src, testHeader(1, 0), op, nil)
+	if err != nil || rpcErr != nil {
+		t.Fatalf("deploying the sender: %v, %v", rpcErr, err)
+	}
+	if code := overrides[sender].Code; code == nil || !bytes.Equal(*code, runtime) {
+		t.Fatalf("sender code override %v, want the runtime code", code)
+	}
+	gas, rpcErr, err := estimateCallGas(client, entryPointV06, op, overrides)
+	if err != nil || rpcErr != nil {
+		t.Fatalf("estimate: %v, %v", rpcErr, err)
+	}
+	// the fresh SSTORE alone costs 22100
+	if gas < params.SstoreSetGasEIP2200+params.ColdSloadCostEIP2929 {
+		t.Errorf("estimated %d gas, less than the account's storage writes", gas)
+	}
+
+	op.Sender = common.HexToAddress("0xa1")
+	if _, rpcErr, err := withDeployedSender(params.AllEthashProtocolChanges, entryPointV06, src, testHeader(1, 0), op, nil); err != nil || rpcErr == nil {
+		t.Errorf("initCode deploying another sender: got %v, %v, want a rejection", rpcErr, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// HashRequest is a JSON-RPC request whose only param is a userOpHash.
+type HashRequest struct {
+	Jsonrpc string        `json:"jsonrpc"`
+	Id      *big.Int      `json:"id"`
+	Method  string        `json:"method"`
+	Params  []common.Hash `json:"params"`
+}
+
+type UserOperationByHashResult struct {
+	UserOperation   interface{}    `json:"userOperation"`
+	EntryPoint      common.Address `json:"entryPoint"`
+	TransactionHash *common.Hash   `json:"transactionHash"`
+	BlockHash       *common.Hash   `json:"blockHash"`
+	BlockNumber     *hexutil.Big   `json:"blockNumber"`
+	Sender          common.Address `json:"sender"`
+	Factory         common.Address `json:"factory"`
+	Paymaster       common.Address `json:"paymaster"`
+	Aggregator      common.Address `json:"aggregator"`
+}
+
+// handle_eth_getUserOperationByHash returns an op accepted by this bundler,
+// with the transaction and block it was included in once it is mined. The
+// result is null for unknown hashes.
+func (b *bundler) handle_eth_getUserOperationByHash(respw http.ResponseWriter, req *http.Request) {
+	respw.Header().Set("Content-Type", "application/json")
+	respw.WriteHeader(200)
+	var r HashRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.Params) != 1 {
+		http.Error(respw, "invalid number of params for eth_getUserOperationByHash", e.JsonRpcInvalidParams)
+		return
+	}
+	stored, ok := b.userOps.Get(r.Params[0])
+	if !ok {
+		json.NewEncoder(respw).Encode(NewRPCResult(r.Id, nil))
+		return
+	}
+	var op interface{} = stored.Op
+	if b.chain.version(stored.EntryPoint) == entryPointVersion07 {
+		op = stored.Op.toV07JSON()
+	}
+	res := UserOperationByHashResult{
+		UserOperation: op,
+		EntryPoint:    stored.EntryPoint,
+		Sender:        stored.Entities.Sender,
+		Factory:       stored.Entities.Factory,
+		Paymaster:     stored.Entities.Paymaster,
+		Aggregator:    stored.Entities.Aggregator,
+	}
+	if stored.TxHash != (common.Hash{}) {
+		res.TransactionHash = &stored.TxHash
+		// a missing receipt means the bundle is not mined yet
+		if receipt, err := b.nodes.Eth().TransactionReceipt(context.Background(), stored.TxHash); err == nil {
+			res.BlockHash = &receipt.BlockHash
+			res.BlockNumber = (*hexutil.Big)(receipt.BlockNumber)
+		}
+	}
+	json.NewEncoder(respw).Encode(NewRPCResult(r.Id, res))
+}
@@ -1,63 +1,233 @@
-package main
-
-import (
-	"context"
-	"fmt"
-	"os"
-	"reflect"
-
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	"github.com/ethereum/go-ethereum/common"
-	typ "github.com/ethereum/go-ethereum/core/types"
-	ethclient "github.com/ethereum/go-ethereum/ethclient"
-)
-
-func (s _UserOperation) CallHandleOps() (bool, *typ.Transaction, error) {
-	conn, err := ethclient.Dial(getClient())
-	if err != nil {
-		return false, nil, err
-	}
-	EP, err := NewEntryPoint(common.HexToAddress(os.Getenv("ENTRYPOINT_CONTRACT")), conn)
-	if err != nil {
-		return false, nil, err
-	}
-	chainID, _ := conn.ChainID(context.Background())
-	fmt.Println("chain id", chainID)
-	rdr := string(os.Getenv("KEY_IN"))
-	r, err := os.Open(rdr)
-	if err != nil {
-		return false, nil, err
-	}
-	auth, err := bind.NewTransactorWithChainID(r, os.Getenv("PASSPHRASE"), chainID)
-	if err != nil {
-		return false, nil, err
-	}
-
-	uop_array := buildUserOperationArray(s)
-	fmt.Println(reflect.TypeOf(uop_array[0].Nonce))
-	tx, err := EP.HandleOps(auth, uop_array, common.HexToAddress(os.Getenv("TEMP_BENEFICIARY")))
-	if err != nil {
-		return false, nil, err
-	}
-	return true, tx, nil
-
-}
-
-func buildUserOperationArray(uop _UserOperation) []UserOperation {
-	var ops = []UserOperation{
-		{
-			Sender:               uop.Sender,
-			Nonce:                uop.Nonce,
-			InitCode:             []byte(uop.InitCode),
-			CallData:             []byte(uop.CallData),
-			CallGasLimit:         uop.CallGasLimit,
-			VerificationGasLimit: uop.VerificationGasLimit,
-			PreVerificationGas:   uop.PreVerificationGas,
-			MaxFeePerGas:         uop.MaxFeePerGas,
-			MaxPriorityFeePerGas: uop.MaxPriorityFeePerGas,
-			PaymasterAndData:     []byte(uop.PaymasterAndData),
-			Signature:            []byte(uop.Signature),
-		},
-	}
-	return ops
-}
+package main
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	typ "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// callHandleOps submits ops to the EntryPoint ep in a single handleOps
+// transaction.
+func (b *bundler) callHandleOps(ep common.Address, ops []_UserOperation) (bool, *typ.Transaction, error) {
+	auth, err := b.transactOpts()
+	if err != nil {
+		return false, nil, err
+	}
+	tx, err := b.nonces.send(func(nonce uint64) (*typ.Transaction, error) {
+		auth.Nonce = new(big.Int).SetUint64(nonce)
+		if b.chain.version(ep) == entryPointVersion07 {
+			return b.callHandleOpsV07(auth, ep, ops)
+		}
+		return b.callHandleOpsV06(auth, ep, ops)
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	b.userOps.Submitted(ep, ops, tx.Hash())
+	go b.trackInclusion(tx, ops)
+	return true, tx, nil
+
+}
+
+// callHandleOpsV06 submits ops to an EntryPoint that takes UserOperations.
+func (b *bundler) callHandleOpsV06(auth *bind.TransactOpts, ep common.Address, ops []_UserOperation) (*typ.Transaction, error) {
+	EP, err := NewEntryPoint(ep, b.nodes.Eth())
+	if err != nil {
+		return nil, err
+	}
+	uop_array := buildUserOperationArray(ops...)
+	return EP.HandleOps(auth, uop_array, b.settings.beneficiary(auth.From))
+}
+
+// callHandleOpsV07 submits ops packed as EntryPoint v0.7 takes them.
+func (b *bundler) callHandleOpsV07(auth *bind.TransactOpts, ep common.Address, ops []_UserOperation) (*typ.Transaction, error) {
+	EP, err := NewEntryPointV07(ep, b.nodes.Eth())
+	if err != nil {
+		return nil, err
+	}
+	packed := make([]PackedUserOperation, len(ops))
+	for i, op := range ops {
+		packed[i] = packUserOperationV07(op)
+	}
+	return EP.HandleOps(auth, packed, b.settings.beneficiary(auth.From))
+}
+
+// transactOpts returns the signer's options with the fees of the next block
+// set from the head cache, so that submitting does not fetch the head again.
+func (b *bundler) transactOpts() (*bind.TransactOpts, error) {
+	baseFee, err := b.heads.nextBaseFee()
+	if err != nil {
+		return nil, err
+	}
+	tip, err := b.nodes.Eth().SuggestGasTipCap(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	opts := *b.signer
+	opts.GasTipCap = tip
+	// room for the base fee to double before the bundle is mined
+	opts.GasFeeCap = new(big.Int).Add(tip, new(big.Int).Mul(baseFee, big.NewInt(2)))
+	return &opts, nil
+}
+
+// bundleLoop submits the ops queued in the mempool on every head until the
+// process exits. It is the only place bundles are sent from.
+func (b *bundler) bundleLoop(heads <-chan *typ.Header) {
+	for range heads {
+		b.submitPendingOps()
+	}
+}
+
+// submitPendingOps bundles the ops queued in the mempool that are currently
+// valid into one handleOps transaction per EntryPoint, unless the last bundle
+// was sent less than BundleInterval ago. The ops are queued again if the
+// submission fails.
+func (b *bundler) submitPendingOps() {
+	if b.isBundlingPaused() || time.Since(b.lastBundle) < b.settings.BundleInterval {
+		return
+	}
+	gasLimit, err := b.heads.gasLimit()
+	if err != nil {
+		log.Error("failed to get block gas limit", "chain", b.chain.ChainID, "error", err)
+		return
+	}
+	byEntryPoint := map[common.Address][]mempoolEntry{}
+	for _, entry := range b.pool.Drain(time.Now()) {
+		byEntryPoint[entry.EntryPoint] = append(byEntryPoint[entry.EntryPoint], entry)
+	}
+	for _, ep := range b.chain.EntryPoints {
+		if b.submitBundle(ep, byEntryPoint[ep], gasLimit) {
+			b.lastBundle = time.Now()
+		}
+	}
+}
+
+// submitBundle sends the ops selected from entries to the EntryPoint ep and
+// tells if a bundle was sent.
+func (b *bundler) submitBundle(ep common.Address, entries []mempoolEntry, gasLimit uint64) bool {
+	entries, deferred := selectBundle(b.reputation, entries, gasLimit)
+	b.pool.Requeue(deferred...)
+	if len(entries) == 0 {
+		return false
+	}
+	ops := make([]_UserOperation, len(entries))
+	for i, entry := range entries {
+		ops[i] = entry.Op
+	}
+	_, tx, err := b.callHandleOps(ep, ops)
+	if err != nil {
+		log.Error("failed to submit pending user operations", "chain", b.chain.ChainID, "entryPoint", ep, "ops", len(ops), "error", err)
+		b.pool.Requeue(dropFailedOp(b.reputation, entries, err)...)
+		return false
+	}
+	log.Info("submitted pending user operations", "chain", b.chain.ChainID, "entryPoint", ep, "ops", len(ops), "tx", tx.Hash())
+	return true
+}
+
+// dropFailedOp removes the op blamed by a FailedOp revert of handleOps from
+// entries and crashes the reputation of the factory or paymaster it blames.
+// For any other error entries are returned as they are.
+func dropFailedOp(reputation *reputationManager, entries []mempoolEntry, err error) []mempoolEntry {
+	data, ok := revertData(err)
+	if !ok {
+		return entries
+	}
+	reason := decodeRevert(data)
+	if reason.OpIndex == nil || !reason.OpIndex.IsUint64() || reason.OpIndex.Uint64() >= uint64(len(entries)) {
+		return entries
+	}
+	i := int(reason.OpIndex.Uint64())
+	op := entries[i].Op
+	entities := opEntities(op)
+	switch reason.Entity {
+	case entityFactory:
+		reputation.Crashed(entities.Factory)
+	case entityPaymaster:
+		reputation.Crashed(entities.Paymaster)
+	}
+	log.Warn("dropping user operation that made handleOps revert", "sender", op.Sender, "nonce", op.Nonce, "reason", reason.Reason)
+	return append(entries[:i:i], entries[i+1:]...)
+}
+
+// selectBundle picks the ops to put in the next bundle. Ops of banned
+// entities are dropped, and ops of throttled entities beyond
+// throttledEntityBundleCount, or that would take the bundle over gasLimit,
+// are deferred to a later bundle.
+func selectBundle(reputation *reputationManager, entries []mempoolEntry, gasLimit uint64) (bundle, deferred []mempoolEntry) {
+	included := map[common.Address]int{}
+	gas, limit := new(big.Int), new(big.Int).SetUint64(gasLimit)
+next:
+	for _, entry := range entries {
+		op := entry.Op
+		entities := opEntities(op).reputationEntities()
+		for _, addr := range entities {
+			switch reputation.Status(addr) {
+			case statusBanned:
+				log.Warn("dropping user operation of banned entity", "sender", op.Sender, "entity", addr)
+				continue next
+			case statusThrottled:
+				if included[addr] >= throttledEntityBundleCount {
+					deferred = append(deferred, entry)
+					continue next
+				}
+			}
+		}
+		opGas := maxOpGas(op)
+		if opGas.Cmp(limit) > 0 {
+			log.Warn("dropping user operation over the block gas limit", "sender", op.Sender, "gas", opGas, "limit", gasLimit)
+			continue
+		}
+		if new(big.Int).Add(gas, opGas).Cmp(limit) > 0 {
+			deferred = append(deferred, entry)
+			continue
+		}
+		gas.Add(gas, opGas)
+		for _, addr := range entities {
+			included[addr]++
+		}
+		bundle = append(bundle, entry)
+	}
+	return bundle, deferred
+}
+
+// trackInclusion waits for a bundle to be mined and credits the entities of
+// its ops with an inclusion.
+func (b *bundler) trackInclusion(tx *typ.Transaction, ops []_UserOperation) {
+	receipt, err := bind.WaitMined(context.Background(), b.nodes.Eth(), tx)
+	if err != nil {
+		log.Error("failed to wait for bundle", "tx", tx.Hash(), "error", err)
+		return
+	}
+	if receipt.Status != typ.ReceiptStatusSuccessful {
+		log.Warn("bundle reverted", "tx", tx.Hash())
+		return
+	}
+	for _, op := range ops {
+		b.reputation.Included(opEntities(op).reputationEntities()...)
+	}
+}
+
+func buildUserOperationArray(uops ..._UserOperation) []UserOperation {
+	var ops = make([]UserOperation, 0, len(uops))
+	for _, uop := range uops {
+		ops = append(ops, UserOperation{
+			Sender:               uop.Sender,
+			Nonce:                uop.Nonce,
+			InitCode:             uop.InitCode,
+			CallData:             uop.CallData,
+			CallGasLimit:         uop.CallGasLimit,
+			VerificationGasLimit: uop.VerificationGasLimit,
+			PreVerificationGas:   uop.PreVerificationGas,
+			MaxFeePerGas:         uop.MaxFeePerGas,
+			MaxPriorityFeePerGas: uop.MaxPriorityFeePerGas,
+			PaymasterAndData:     uop.PaymasterAndData,
+			Signature:            uop.Signature,
+		})
+	}
+	return ops
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// dataError is an RPC error carrying revert data, as returned by eth_call and
+// eth_estimateGas.
+type dataError struct{ data []byte }
+
+func (e dataError) Error() string          { return "execution reverted" }
+func (e dataError) ErrorData() interface{} { return hexutil.Encode(e.data) }
+
+func TestDropFailedOp(t *testing.T) {
+	ep := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	paymaster := common.HexToAddress("0xaa")
+	entries := make([]mempoolEntry, 3)
+	for i := range entries {
+		entries[i] = mempoolEntry{EntryPoint: ep, Op: _UserOperation{Sender: common.BigToAddress(big.NewInt(int64(i + 1))), Nonce: new(big.Int)}}
+	}
+	entries[1].Op.PaymasterAndData = paymaster.Bytes()
+
+	parsed, err := EntryPointMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reputation := &reputationManager{entries: map[common.Address]*reputationEntry{}}
+	failedOp := packError(t, parsed.Errors["FailedOp"], big.NewInt(1), "AA33 reverted")
+	kept := dropFailedOp(reputation, entries, dataError{failedOp})
+	if len(kept) != 2 || kept[0].Op.Sender != entries[0].Op.Sender || kept[1].Op.Sender != entries[2].Op.Sender {
+		t.Fatalf("unexpected entries kept: %+v", kept)
+	}
+	if status := reputation.Status(paymaster); status != statusBanned {
+		t.Errorf("paymaster of the failed op is %v, want BANNED", status)
+	}
+
+	outOfRange := packError(t, parsed.Errors["FailedOp"], big.NewInt(3), "AA33 reverted")
+	if kept := dropFailedOp(reputation, entries, dataError{outOfRange}); len(kept) != 3 {
+		t.Errorf("FailedOp with an index out of range dropped ops: %+v", kept)
+	}
+	if kept := dropFailedOp(reputation, entries, dataError{[]byte{1, 2, 3, 4}}); len(kept) != 3 {
+		t.Errorf("unknown revert dropped ops: %+v", kept)
+	}
+}
+
+func TestMempoolRequeue(t *testing.T) {
+	pool := &mempool{}
+	ep := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	op := _UserOperation{Sender: common.HexToAddress("0xa1"), Nonce: big.NewInt(1), CallGasLimit: big.NewInt(100)}
+	pool.Add(ep, op, validityWindow{})
+	drained := pool.Drain(time.Now())
+
+	replacement := op
+	replacement.CallGasLimit = big.NewInt(200)
+	pool.Add(ep, replacement, validityWindow{})
+	other := _UserOperation{Sender: common.HexToAddress("0xa2"), Nonce: big.NewInt(1)}
+	pool.Requeue(append(drained, mempoolEntry{EntryPoint: ep, Op: other})...)
+
+	queued := pool.Drain(time.Now())
+	if len(queued) != 2 {
+		t.Fatalf("expected 2 queued ops, got %d", len(queued))
+	}
+	if queued[0].Op.CallGasLimit.Int64() != 200 {
+		t.Errorf("requeue overwrote the replacement: %+v", queued[0].Op)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// headCacheSize is how many recent headers are kept, the blocks BLOCKHASH can
+// reach.
+const headCacheSize = 256
+
+// headCache follows the head of a chain and keeps its latest headers, so that
+// validation and bundling do not fetch blocks from the node. Heads come from
+// a newHeads subscription when the node connection supports one, and from
+// polling every interval otherwise.
+type headCache struct {
+	conn     *ethclient.Client
+	chain    *chainProfile
+	interval time.Duration
+
+	mu      sync.RWMutex
+	head    *types.Header
+	headers map[uint64]*types.Header
+	subs    []chan *types.Header
+}
+
+func newHeadCache(conn *ethclient.Client, chain *chainProfile, interval time.Duration) *headCache {
+	return &headCache{
+		conn:     conn,
+		chain:    chain,
+		interval: interval,
+		headers:  map[uint64]*types.Header{},
+	}
+}
+
+// run follows the head until the process exits. A dropped subscription is
+// resubscribed, and the head is polled while it is down.
+func (c *headCache) run() {
+	for {
+		err := c.follow()
+		if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			break
+		}
+		log.Warn("newHeads subscription failed", "chain", c.chain.ChainID, "error", err)
+		c.fetch()
+		time.Sleep(c.interval)
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.fetch()
+		<-ticker.C
+	}
+}
+
+// follow adds the heads of a newHeads subscription until it fails.
+func (c *headCache) follow() error {
+	heads := make(chan *types.Header, 16)
+	sub, err := c.conn.SubscribeNewHead(context.Background(), heads)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	c.fetch()
+	for {
+		select {
+		case head := <-heads:
+			c.add(head)
+		case err := <-sub.Err():
+			return err
+		}
+	}
+}
+
+// fetch adds the node's latest header.
+func (c *headCache) fetch() (*types.Header, error) {
+	head, err := c.conn.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		log.Error("failed to get latest header", "chain", c.chain.ChainID, "error", err)
+		return nil, err
+	}
+	c.add(head)
+	return head, nil
+}
+
+// add makes head the latest header. Headers at or above its number that are
+// not its own were reorged out and are dropped.
+func (c *headCache) add(head *types.Header) {
+	n := head.Number.Uint64()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.head != nil {
+		if c.head.Hash() == head.Hash() {
+			return
+		}
+		if n < c.head.Number.Uint64() && c.headers[n] != nil && c.headers[n].Hash() == head.Hash() {
+			// a late notification of a block already passed
+			return
+		}
+	}
+	for number := range c.headers {
+		if number >= n || number+headCacheSize <= n {
+			delete(c.headers, number)
+		}
+	}
+	c.headers[n] = head
+	c.head = head
+	for _, ch := range c.subs {
+		// only the newest head is of interest to a slow reader
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- head
+	}
+}
+
+// newHeads returns a channel that receives every new head. A reader that
+// falls behind only gets the newest one.
+func (c *headCache) newHeads() <-chan *types.Header {
+	ch := make(chan *types.Header, 1)
+	c.mu.Lock()
+	c.subs = append(c.subs, ch)
+	c.mu.Unlock()
+	return ch
+}
+
+// latest returns the latest header, fetching it if none was received yet.
+func (c *headCache) latest() (*types.Header, error) {
+	c.mu.RLock()
+	head := c.head
+	c.mu.RUnlock()
+	if head != nil {
+		return head, nil
+	}
+	return c.fetch()
+}
+
+// header returns the cached header of block n, if any.
+func (c *headCache) header(n uint64) (*types.Header, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h, ok := c.headers[n]
+	return h, ok
+}
+
+// nextBaseFee returns the base fee of the next block. L2s set it by their own
+// rules, so the latest block's is used there.
+func (c *headCache) nextBaseFee() (*big.Int, error) {
+	head, err := c.latest()
+	if err != nil {
+		return nil, err
+	}
+	if c.chain.L2 != l2None {
+		if head.BaseFee == nil {
+			return new(big.Int), nil
+		}
+		return new(big.Int).Set(head.BaseFee), nil
+	}
+	return misc.CalcBaseFee(c.chain.Config, head), nil
+}
+
+// gasLimit returns the gas limit of the latest block.
+func (c *headCache) gasLimit() (uint64, error) {
+	head, err := c.latest()
+	if err != nil {
+		return 0, err
+	}
+	return head.GasLimit, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func testHeader(n uint64, extra byte) *types.Header {
+	return &types.Header{
+		Number:   new(big.Int).SetUint64(n),
+		GasLimit: 30_000_000,
+		GasUsed:  20_000_000,
+		BaseFee:  big.NewInt(params.GWei),
+		Extra:    []byte{extra},
+	}
+}
+
+func TestHeadCacheFollowsHead(t *testing.T) {
+	heads := newHeadCache(nil, chainProfiles["sepolia"], 0)
+	notified := heads.newHeads()
+	for n := uint64(1); n <= headCacheSize+10; n++ {
+		heads.add(testHeader(n, 0))
+	}
+	if _, ok := heads.header(10); ok {
+		t.Error("kept a header older than headCacheSize blocks")
+	}
+	if _, ok := heads.header(headCacheSize + 9); !ok {
+		t.Error("dropped a recent header")
+	}
+	if head := <-notified; head.Number.Uint64() != headCacheSize+10 {
+		t.Errorf("notified of head %v, want only the newest", head.Number)
+	}
+
+	// a reorg replaces the head and drops the headers above it
+	reorged := testHeader(headCacheSize+9, 1)
+	heads.add(reorged)
+	latest, err := heads.latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Hash() != reorged.Hash() {
+		t.Fatal("head was not replaced on reorg")
+	}
+	if _, ok := heads.header(headCacheSize + 10); ok {
+		t.Error("kept a reorged header")
+	}
+
+	want := misc.CalcBaseFee(chainProfiles["sepolia"].Config, reorged)
+	if baseFee, err := heads.nextBaseFee(); err != nil || baseFee.Cmp(want) != 0 {
+		t.Errorf("nextBaseFee = %v, %v, want %v", baseFee, err, want)
+	}
+	if want.Cmp(reorged.BaseFee) <= 0 {
+		t.Errorf("next base fee %v did not rise after a block above its target", want)
+	}
+}
+
+func TestSelectBundleGasLimit(t *testing.T) {
+	op := func(sender byte, gas int64) mempoolEntry {
+		return mempoolEntry{Op: _UserOperation{
+			Sender:               common.Address{sender},
+			CallGasLimit:         big.NewInt(gas),
+			VerificationGasLimit: new(big.Int),
+			PreVerificationGas:   new(big.Int),
+		}}
+	}
+	reputation := &reputationManager{entries: map[common.Address]*reputationEntry{}}
+	bundle, deferred := selectBundle(reputation, []mempoolEntry{op(1, 600), op(2, 600), op(3, 400), op(4, 2000)}, 1000)
+	if len(bundle) != 2 || bundle[0].Op.Sender != (common.Address{1}) || bundle[1].Op.Sender != (common.Address{3}) {
+		t.Errorf("bundled %v, want the ops of senders 1 and 3", bundle)
+	}
+	if len(deferred) != 1 || deferred[0].Op.Sender != (common.Address{2}) {
+		t.Errorf("deferred %v, want the op of sender 2 only", deferred)
+	}
+}
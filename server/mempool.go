@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// mempool holds validated user operations that have not been submitted to
+// their EntryPoint yet, e.g. because bundling is paused or they are not valid
+// yet. Ops of different EntryPoints share it but never replace each other.
+type mempool struct {
+	mu  sync.Mutex
+	ops []mempoolEntry
+}
+
+type mempoolEntry struct {
+	EntryPoint common.Address
+	Op         _UserOperation
+	Window     validityWindow
+}
+
+// Add queues op for the EntryPoint ep until it can be included in a bundle.
+// An op for ep from the same sender with the same nonce replaces the one
+// already queued.
+func (m *mempool) Add(ep common.Address, op _UserOperation, window validityWindow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := mempoolEntry{EntryPoint: ep, Op: op, Window: window}
+	if i := m.indexOf(ep, op); i >= 0 {
+		m.ops[i] = entry
+		return
+	}
+	m.ops = append(m.ops, entry)
+}
+
+// Requeue puts back entries taken out by Drain. An entry is left out if an op
+// for its EntryPoint from the same sender with the same nonce was queued in
+// the meantime, so a replacement is not overwritten by the op it replaced.
+func (m *mempool) Requeue(entries ...mempoolEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range entries {
+		if m.indexOf(entry.EntryPoint, entry.Op) < 0 {
+			m.ops = append(m.ops, entry)
+		}
+	}
+}
+
+// indexOf returns the index of the op queued for ep from the sender of op
+// with its nonce, or -1. The caller holds m.mu.
+func (m *mempool) indexOf(ep common.Address, op _UserOperation) int {
+	for i, queued := range m.ops {
+		if queued.EntryPoint == ep && sameSenderAndNonce(queued.Op, op) {
+			return i
+		}
+	}
+	return -1
+}
+
+// HasRoom tells if op for the EntryPoint ep can be added without the mempool
+// holding more than maxOps ops. An op replacing a queued one always can. Zero
+// is no limit.
+func (m *mempool) HasRoom(ep common.Address, op _UserOperation, maxOps uint64) bool {
+	if maxOps == 0 {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.indexOf(ep, op) >= 0 || uint64(len(m.ops)) < maxOps
+}
+
+// Drain removes and returns the queued ops that are valid at now. Ops that
+// are not valid yet stay queued, and expired ops are dropped.
+func (m *mempool) Drain(now time.Time) []mempoolEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ready, queued []mempoolEntry
+	for _, entry := range m.ops {
+		switch {
+		case entry.Window.expiresWithin(now, 0):
+			log.Warn("dropping expired user operation", "sender", entry.Op.Sender, "nonce", entry.Op.Nonce, "validUntil", entry.Window.ValidUntil)
+		case entry.Window.notYetValid(now):
+			queued = append(queued, entry)
+		default:
+			ready = append(ready, entry)
+		}
+	}
+	m.ops = queued
+	return ready
+}
+
+// Len returns the number of queued ops.
+func (m *mempool) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.ops)
+}
+
+// CountEntity returns how many queued ops use addr as paymaster or factory.
+func (m *mempool) CountEntity(addr common.Address) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, entry := range m.ops {
+		for _, entity := range opEntities(entry.Op).reputationEntities() {
+			if entity == addr {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// CommittedPrefund returns the prefund of the ops queued for the EntryPoint
+// ep paid for by payer, leaving out the op that replacement would replace.
+// Each EntryPoint holds its own deposits.
+func (m *mempool) CommittedPrefund(ep, payer common.Address, replacement _UserOperation) *big.Int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := new(big.Int)
+	for _, entry := range m.ops {
+		if entry.EntryPoint != ep || sameSenderAndNonce(entry.Op, replacement) {
+			continue
+		}
+		if _, p := opEntities(entry.Op).payer(); p == payer {
+			total.Add(total, requiredPrefund(entry.Op))
+		}
+	}
+	return total
+}
+
+func sameSenderAndNonce(a, b _UserOperation) bool {
+	return a.Sender == b.Sender && bigEqual(a.Nonce, b.Nonce)
+}
+
+func bigEqual(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(b) == 0
+}
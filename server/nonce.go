@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+)
+
+// accountNonces sends the transactions of one account one at a time and hands
+// out their nonces, so that a bundle and a sweep sent together by different
+// goroutines do not both take the pending nonce.
+type accountNonces struct {
+	conn *ethclient.Client
+	from common.Address
+
+	mu    sync.Mutex
+	next  uint64
+	known bool
+}
+
+func newAccountNonces(conn *ethclient.Client, from common.Address) *accountNonces {
+	return &accountNonces{conn: conn, from: from}
+}
+
+// send calls sendTx with the account's next nonce while holding back every
+// other transaction of the account. The nonce is fetched from the node again
+// after a failure, as the transaction may or may not have reached it.
+func (n *accountNonces) send(sendTx func(nonce uint64) (*types.Transaction, error)) (*types.Transaction, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.known {
+		nonce, err := n.conn.PendingNonceAt(context.Background(), n.from)
+		if err != nil {
+			return nil, err
+		}
+		n.next, n.known = nonce, true
+	}
+	tx, err := sendTx(n.next)
+	if err != nil {
+		n.known = false
+		return nil, err
+	}
+	n.next++
+	return tx, nil
+}
+
+// reset makes the next send fetch the nonce from the node again, e.g. after a
+// transaction was dropped without taking its nonce.
+func (n *accountNonces) reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.known = false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeNonce answers eth_getTransactionCount.
+type fakeNonce struct {
+	mu    sync.Mutex
+	count uint64
+}
+
+func (f *fakeNonce) GetTransactionCount(addr common.Address, block string) hexutil.Uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return hexutil.Uint64(f.count)
+}
+
+func TestAccountNonces(t *testing.T) {
+	node := &fakeNonce{count: 5}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", node); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+	nonces := newAccountNonces(ethclient.NewClient(client), common.Address{1})
+
+	var mu sync.Mutex
+	used := map[uint64]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := nonces.send(func(nonce uint64) (*types.Transaction, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if used[nonce] {
+					t.Errorf("nonce %d handed out twice", nonce)
+				}
+				used[nonce] = true
+				return new(types.Transaction), nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for nonce := uint64(5); nonce < 15; nonce++ {
+		if !used[nonce] {
+			t.Errorf("nonce %d was skipped", nonce)
+		}
+	}
+
+	// a failed send makes the next one ask the node again
+	node.mu.Lock()
+	node.count = 20
+	node.mu.Unlock()
+	nonces.send(func(nonce uint64) (*types.Transaction, error) { return nil, errors.New("rejected") })
+	nonces.send(func(nonce uint64) (*types.Transaction, error) {
+		if nonce != 20 {
+			t.Errorf("got nonce %d after a failure, want the node's 20", nonce)
+		}
+		return new(types.Transaction), nil
+	})
+}
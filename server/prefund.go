@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// paymasterVerificationGasMultiplier is how many times the EntryPoint charges
+// verificationGasLimit for ops with a paymaster, to cover postOp.
+const paymasterVerificationGasMultiplier = 3
+
+// requiredPrefund is the most the EntryPoint can charge for op.
+func requiredPrefund(op _UserOperation) *big.Int {
+	return new(big.Int).Mul(maxOpGas(op), op.MaxFeePerGas)
+}
+
+// maxOpGas is the most gas the EntryPoint can charge op for.
+func maxOpGas(op _UserOperation) *big.Int {
+	if op.PaymasterVerificationGasLimit != nil {
+		// v0.7 ops limit the paymaster's gas separately
+		gas := new(big.Int).Add(op.VerificationGasLimit, op.CallGasLimit)
+		gas.Add(gas, op.PreVerificationGas)
+		gas.Add(gas, op.PaymasterVerificationGasLimit)
+		return gas.Add(gas, op.PaymasterPostOpGasLimit)
+	}
+	mul := big.NewInt(1)
+	if getPaymaster(op) != zeroAddress {
+		mul = big.NewInt(paymasterVerificationGasMultiplier)
+	}
+	gas := new(big.Int).Mul(op.VerificationGasLimit, mul)
+	gas.Add(gas, op.CallGasLimit)
+	return gas.Add(gas, op.PreVerificationGas)
+}
+
+// payer returns who pays for op: the paymaster if there is one, else the
+// sender.
+func (v validationEntities) payer() (string, common.Address) {
+	if v.Paymaster != zeroAddress {
+		return entityPaymaster, v.Paymaster
+	}
+	return entityAccount, v.Sender
+}
+
+// payerFunds looks up what a payer can spend on ops: its EntryPoint deposit,
+// plus its ETH balance for senders without a deposit, as those pay the
+// EntryPoint during validation.
+type payerFunds func(payer common.Address, isSender bool) (*big.Int, error)
+
+// checkPrefund rejects op if its payer cannot cover its prefund on top of the
+// prefund of the payer's other ops for the EntryPoint ep in the mempool. An
+// error is only returned when funds fails.
+func checkPrefund(pool *mempool, ep common.Address, op _UserOperation, entities validationEntities, funds payerFunds) (*RPCError, error) {
+	entity, payer := entities.payer()
+	available, err := funds(payer, entity == entityAccount)
+	if err != nil {
+		return nil, err
+	}
+	required := requiredPrefund(op)
+	committed := pool.CommittedPrefund(ep, payer, op)
+	if new(big.Int).Add(required, committed).Cmp(available) <= 0 {
+		return nil, nil
+	}
+	code := e.JsonRpcRejectedByEntryPointOrAccount
+	if entity == entityPaymaster {
+		code = e.JsonRpcRejectedByPaymaster
+	}
+	return &RPCError{
+		Code:    code,
+		Message: fmt.Sprintf("%s %s has %v wei, needs %v for the op and %v for its ops in the mempool", entity, payer, available, required, committed),
+		Data: map[string]interface{}{
+			"entity":    entity,
+			"address":   payer,
+			"available": available,
+			"prefund":   required,
+			"committed": committed,
+		},
+	}, nil
+}
+
+// payerFunds reads the funds of payers from the EntryPoint ep and the chain.
+func (b *bundler) payerFunds(ep common.Address) payerFunds {
+	return func(payer common.Address, isSender bool) (*big.Int, error) {
+		conn := b.nodes.Eth()
+		EP, err := NewEntryPoint(ep, conn)
+		if err != nil {
+			return nil, err
+		}
+		deposit, err := EP.BalanceOf(nil, payer)
+		if err != nil {
+			return nil, err
+		}
+		if !isSender || deposit.Sign() > 0 {
+			return deposit, nil
+		}
+		return conn.BalanceAt(context.Background(), payer, nil)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPrefund(t *testing.T) {
+	pool := &mempool{}
+
+	sender := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	paymaster := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	ep, otherEP := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"), common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032")
+	op := func(nonce int64, withPaymaster bool) _UserOperation {
+		o := _UserOperation{
+			Sender:               sender,
+			Nonce:                big.NewInt(nonce),
+			CallGasLimit:         big.NewInt(100),
+			VerificationGasLimit: big.NewInt(100),
+			PreVerificationGas:   big.NewInt(100),
+			MaxFeePerGas:         big.NewInt(10),
+		}
+		if withPaymaster {
+			o.PaymasterAndData = paymaster.Bytes()
+		}
+		return o
+	}
+	if got := requiredPrefund(op(0, false)); got.Int64() != 3000 {
+		t.Fatalf("prefund without paymaster = %v, want 3000", got)
+	}
+	if got := requiredPrefund(op(0, true)); got.Int64() != 5000 {
+		t.Fatalf("prefund with paymaster = %v, want 5000", got)
+	}
+
+	funds := func(payer common.Address, isSender bool) (*big.Int, error) {
+		if isSender != (payer == sender) {
+			t.Fatalf("funds(%s, %v) called with wrong isSender", payer, isSender)
+		}
+		return big.NewInt(8000), nil
+	}
+	check := func(o _UserOperation) *RPCError {
+		rpcErr, err := checkPrefund(pool, ep, o, opEntities(o), funds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rpcErr
+	}
+
+	pool.Add(ep, op(0, true), validityWindow{})
+	if rpcErr := check(op(1, true)); rpcErr == nil || rpcErr.Code != e.JsonRpcRejectedByPaymaster {
+		t.Fatalf("paymaster over committed: got %v, want code %d", rpcErr, e.JsonRpcRejectedByPaymaster)
+	}
+	if rpcErr := check(op(0, true)); rpcErr != nil {
+		t.Fatalf("replacing the queued op: %v", rpcErr)
+	}
+	if rpcErr := check(op(1, false)); rpcErr != nil {
+		t.Fatalf("sender ops are not charged for paymaster ops: %v", rpcErr)
+	}
+	pool.Add(ep, op(1, false), validityWindow{})
+	pool.Add(ep, op(2, false), validityWindow{})
+	if rpcErr := check(op(3, false)); rpcErr == nil || rpcErr.Code != e.JsonRpcRejectedByEntryPointOrAccount {
+		t.Fatalf("sender over committed: got %v, want code %d", rpcErr, e.JsonRpcRejectedByEntryPointOrAccount)
+	}
+	if rpcErr, _ := checkPrefund(pool, otherEP, op(3, false), opEntities(op(3, false)), funds); rpcErr != nil {
+		t.Fatalf("charged for ops of another EntryPoint: %v", rpcErr)
+	}
+	if pool.Add(otherEP, op(0, false), validityWindow{}); pool.Len() != 4 {
+		t.Fatalf("op of another EntryPoint replaced a queued op: %d ops, want 4", pool.Len())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// gasOverheads are the costs covered by preVerificationGas: the calldata of
+// the op, its share of the bundle transaction and what the EntryPoint spends
+// on it outside of validation and execution.
+type gasOverheads struct {
+	// Fixed is the cost of the bundle transaction, shared by BundleSize ops.
+	Fixed      uint64
+	BundleSize uint64
+	// PerUserOp and PerUserOpWord are spent by the EntryPoint on every op and
+	// on every word of its packed encoding.
+	PerUserOp     uint64
+	PerUserOpWord uint64
+	// ZeroByte and NonZeroByte are the calldata cost of the packed op.
+	ZeroByte    uint64
+	NonZeroByte uint64
+}
+
+var defaultGasOverheads = gasOverheads{
+	Fixed:         21000,
+	BundleSize:    1,
+	PerUserOp:     18300,
+	PerUserOpWord: 4,
+	ZeroByte:      4,
+	NonZeroByte:   16,
+}
+
+// overheads returns the configured overheads.
+func overheads() gasOverheads {
+	if conf == nil {
+		return defaultGasOverheads
+	}
+	return conf.GasOverheads
+}
+
+// preVerificationGas returns the preVerificationGas op to an EntryPoint of
+// version must at least have.
+func (o gasOverheads) preVerificationGas(version entryPointVersion, op _UserOperation) (uint64, error) {
+	packed, err := packUserOp(version, op)
+	if err != nil {
+		return 0, err
+	}
+	callDataCost := uint64(0)
+	for _, b := range packed {
+		if b == 0 {
+			callDataCost += o.ZeroByte
+		} else {
+			callDataCost += o.NonZeroByte
+		}
+	}
+	words := uint64(len(packed)+31) / 32
+	return callDataCost + o.Fixed/o.BundleSize + o.PerUserOp + o.PerUserOpWord*words, nil
+}
+
+// packUserOp ABI encodes op the way it is passed to handleOps of an
+// EntryPoint of version: as a UserOperation for v0.6 and as a
+// PackedUserOperation for v0.7.
+func packUserOp(version entryPointVersion, op _UserOperation) ([]byte, error) {
+	getAbi, packed := EntryPointMetaData.GetAbi, interface{}(buildUserOperationArray(op)[0])
+	if version == entryPointVersion07 {
+		getAbi, packed = EntryPointV07MetaData.GetAbi, packUserOperationV07(op)
+	}
+	epABI, err := getAbi()
+	if err != nil {
+		return nil, err
+	}
+	method, ok := epABI.Methods["handleOps"]
+	if !ok || len(method.Inputs) == 0 || method.Inputs[0].Type.Elem == nil {
+		return nil, fmt.Errorf("EntryPoint v%s ABI has no handleOps(ops, beneficiary)", version)
+	}
+	return abi.Arguments{{Type: *method.Inputs[0].Type.Elem}}.Pack(packed)
+}
+
+// checkPreVerificationGas rejects ops to an EntryPoint of version whose
+// preVerificationGas does not cover the overheads.
+func checkPreVerificationGas(version entryPointVersion, op _UserOperation) error {
+	if op.PreVerificationGas == nil {
+		return fmt.Errorf("preVerificationGas is missing")
+	}
+	required, err := overheads().preVerificationGas(version, op)
+	if err != nil {
+		return err
+	}
+	if op.PreVerificationGas.Cmp(new(big.Int).SetUint64(required)) < 0 {
+		return fmt.Errorf("preVerificationGas %v is below the required %d", op.PreVerificationGas, required)
+	}
+	return nil
+}
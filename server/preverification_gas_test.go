@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPreVerificationGas(t *testing.T) {
+	op := withEstimationDefaults(_UserOperation{
+		Sender:   common.HexToAddress("0x00000000000000000000000000000000000000a1"),
+		CallData: []byte{0xb6, 0x1d, 0x27, 0xf6, 0, 0, 0, 0},
+	})
+	pvg, err := estimatePreVerificationGas(entryPointVersion06, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := packUserOp(entryPointVersion06, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// v0.7 packs the gas limits and the fees in pairs, two words less
+	packedV07, err := packUserOp(entryPointVersion07, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed)-len(packedV07) != 64 {
+		t.Errorf("v0.7 op packs to %d bytes, v0.6 op to %d", len(packedV07), len(packed))
+	}
+	// the fixed costs alone, plus 4 gas for every byte
+	if min := uint64(21000 + 18300 + 4*len(packed)); pvg < min {
+		t.Fatalf("preVerificationGas %d is below %d", pvg, min)
+	}
+
+	op.PreVerificationGas = new(big.Int).SetUint64(pvg)
+	if err := checkPreVerificationGas(entryPointVersion06, op); err != nil {
+		t.Fatalf("estimated preVerificationGas is rejected: %v", err)
+	}
+	op.PreVerificationGas = new(big.Int).SetUint64(pvg - 1)
+	if err := checkPreVerificationGas(entryPointVersion06, op); err == nil {
+		t.Fatal("preVerificationGas below the estimate is accepted")
+	}
+
+	// a bundle of 4 ops shares the fixed cost
+	op.PreVerificationGas = new(big.Int).SetUint64(pvg)
+	o := defaultGasOverheads
+	o.BundleSize = 4
+	shared, err := o.preVerificationGas(entryPointVersion06, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := pvg - 21000 + 21000/4; shared != want {
+		t.Fatalf("preVerificationGas with bundle size 4 = %d, want %d", shared, want)
+	}
+}
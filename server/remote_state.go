@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+// remoteAccount is an account as stored on chain.
+type remoteAccount struct {
+	Balance     *big.Int
+	Nonce       uint64
+	CodeHash    common.Hash
+	Code        []byte
+	StorageHash common.Hash
+}
+
+// stateSource reads accounts and storage at a fixed block.
+type stateSource interface {
+	Account(addr common.Address) (*remoteAccount, error)
+	Storage(addr common.Address, slot common.Hash) (common.Hash, error)
+}
+
+// nodeState is the state of one block, fetched from the node on first use
+// with eth_getProof, eth_getCode and eth_getStorageAt.
+type nodeState struct {
+	client *rpc.Client
+	header *types.Header
+
+	mu       sync.Mutex
+	accounts map[common.Address]*remoteAccount
+	storage  map[common.Address]map[common.Hash]common.Hash
+}
+
+func newNodeState(client *rpc.Client, header *types.Header) *nodeState {
+	return &nodeState{
+		client:   client,
+		header:   header,
+		accounts: map[common.Address]*remoteAccount{},
+		storage:  map[common.Address]map[common.Hash]common.Hash{},
+	}
+}
+
+func (s *nodeState) Account(addr common.Address) (*remoteAccount, error) {
+	s.mu.Lock()
+	acct, ok := s.accounts[addr]
+	s.mu.Unlock()
+	if ok {
+		return acct, nil
+	}
+	ctx := context.Background()
+	proof, err := gethclient.New(s.client).GetProof(ctx, addr, nil, s.header.Number)
+	if err != nil {
+		return nil, err
+	}
+	acct = &remoteAccount{
+		Balance:     proof.Balance,
+		Nonce:       proof.Nonce,
+		CodeHash:    proof.CodeHash,
+		StorageHash: proof.StorageHash,
+	}
+	if acct.Balance == nil {
+		acct.Balance = new(big.Int)
+	}
+	if acct.CodeHash != (common.Hash{}) && acct.CodeHash != emptyCodeHash {
+		if acct.Code, err = ethclient.NewClient(s.client).CodeAt(ctx, addr, s.header.Number); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	s.accounts[addr] = acct
+	s.mu.Unlock()
+	return acct, nil
+}
+
+func (s *nodeState) Storage(addr common.Address, slot common.Hash) (common.Hash, error) {
+	s.mu.Lock()
+	value, ok := s.storage[addr][slot]
+	s.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	raw, err := ethclient.NewClient(s.client).StorageAt(context.Background(), addr, slot, s.header.Number)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	value = common.BytesToHash(raw)
+	s.mu.Lock()
+	if s.storage[addr] == nil {
+		s.storage[addr] = map[common.Hash]common.Hash{}
+	}
+	s.storage[addr][slot] = value
+	s.mu.Unlock()
+	return value, nil
+}
+
+// nodeStateCache keeps the fetched state of the latest block, so that
+// simulations in the same block share it.
+type nodeStateCache struct {
+	mu    sync.Mutex
+	state *nodeState
+}
+
+// at returns the state of the block of header, fetched through client.
+func (c *nodeStateCache) at(client *rpc.Client, header *types.Header) *nodeState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == nil || c.state.header.Hash() != header.Hash() {
+		c.state = newNodeState(client, header)
+	}
+	return c.state
+}
+
+// remoteState is a vm.StateDB over a stateSource. Changes made during
+// execution are kept in memory and never written back. Errors of the source
+// cannot be returned through vm.StateDB, so the first one is kept in err and
+// the missing value reads as zero.
+type remoteState struct {
+	src stateSource
+	err error
+
+	accounts map[common.Address]*stateAccount
+	refund   uint64
+	logs     []*types.Log
+
+	accessAddrs map[common.Address]bool
+	accessSlots map[common.Address]map[common.Hash]bool
+
+	journal []func()
+}
+
+type stateAccount struct {
+	exists   bool
+	balance  *big.Int
+	nonce    uint64
+	code     []byte
+	suicided bool
+	// cleared is set when the account is recreated, hiding its old storage.
+	cleared   bool
+	committed map[common.Hash]common.Hash
+	dirty     map[common.Hash]common.Hash
+}
+
+func newRemoteState(src stateSource) *remoteState {
+	return &remoteState{
+		src:         src,
+		accounts:    map[common.Address]*stateAccount{},
+		accessAddrs: map[common.Address]bool{},
+		accessSlots: map[common.Address]map[common.Hash]bool{},
+	}
+}
+
+func (s *remoteState) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *remoteState) account(addr common.Address) *stateAccount {
+	if acct, ok := s.accounts[addr]; ok {
+		return acct
+	}
+	acct := &stateAccount{
+		balance:   new(big.Int),
+		committed: map[common.Hash]common.Hash{},
+		dirty:     map[common.Hash]common.Hash{},
+	}
+	remote, err := s.src.Account(addr)
+	if err != nil {
+		s.fail(err)
+	} else {
+		acct.balance = new(big.Int).Set(remote.Balance)
+		acct.nonce = remote.Nonce
+		acct.code = remote.Code
+		acct.exists = remote.Balance.Sign() != 0 || remote.Nonce != 0 || len(remote.Code) != 0 ||
+			(remote.CodeHash != (common.Hash{}) && remote.CodeHash != emptyCodeHash)
+	}
+	s.accounts[addr] = acct
+	return acct
+}
+
+// touch marks acct as existing.
+func (s *remoteState) touch(acct *stateAccount) {
+	if !acct.exists {
+		acct.exists = true
+		s.journal = append(s.journal, func() { acct.exists = false })
+	}
+}
+
+func (s *remoteState) CreateAccount(addr common.Address) {
+	prev := s.account(addr)
+	s.accounts[addr] = &stateAccount{
+		exists:    true,
+		balance:   new(big.Int).Set(prev.balance),
+		cleared:   true,
+		committed: map[common.Hash]common.Hash{},
+		dirty:     map[common.Hash]common.Hash{},
+	}
+	s.journal = append(s.journal, func() { s.accounts[addr] = prev })
+}
+
+func (s *remoteState) SubBalance(addr common.Address, amount *big.Int) {
+	s.setBalance(addr, new(big.Int).Sub(s.GetBalance(addr), amount))
+}
+
+func (s *remoteState) AddBalance(addr common.Address, amount *big.Int) {
+	s.setBalance(addr, new(big.Int).Add(s.GetBalance(addr), amount))
+}
+
+func (s *remoteState) setBalance(addr common.Address, balance *big.Int) {
+	acct := s.account(addr)
+	s.touch(acct)
+	prev := acct.balance
+	acct.balance = balance
+	s.journal = append(s.journal, func() { acct.balance = prev })
+}
+
+func (s *remoteState) GetBalance(addr common.Address) *big.Int {
+	return new(big.Int).Set(s.account(addr).balance)
+}
+
+func (s *remoteState) GetNonce(addr common.Address) uint64 {
+	return s.account(addr).nonce
+}
+
+func (s *remoteState) SetNonce(addr common.Address, nonce uint64) {
+	acct := s.account(addr)
+	s.touch(acct)
+	prev := acct.nonce
+	acct.nonce = nonce
+	s.journal = append(s.journal, func() { acct.nonce = prev })
+}
+
+func (s *remoteState) GetCodeHash(addr common.Address) common.Hash {
+	acct := s.account(addr)
+	if !acct.exists {
+		return common.Hash{}
+	}
+	if len(acct.code) == 0 {
+		return emptyCodeHash
+	}
+	return crypto.Keccak256Hash(acct.code)
+}
+
+func (s *remoteState) GetCode(addr common.Address) []byte {
+	return s.account(addr).code
+}
+
+func (s *remoteState) SetCode(addr common.Address, code []byte) {
+	acct := s.account(addr)
+	s.touch(acct)
+	prev := acct.code
+	acct.code = code
+	s.journal = append(s.journal, func() { acct.code = prev })
+}
+
+func (s *remoteState) GetCodeSize(addr common.Address) int {
+	return len(s.account(addr).code)
+}
+
+func (s *remoteState) AddRefund(gas uint64) {
+	prev := s.refund
+	s.refund += gas
+	s.journal = append(s.journal, func() { s.refund = prev })
+}
+
+func (s *remoteState) SubRefund(gas uint64) {
+	prev := s.refund
+	if gas > s.refund {
+		s.refund = 0
+	} else {
+		s.refund -= gas
+	}
+	s.journal = append(s.journal, func() { s.refund = prev })
+}
+
+func (s *remoteState) GetRefund() uint64 {
+	return s.refund
+}
+
+func (s *remoteState) GetCommittedState(addr common.Address, slot common.Hash) common.Hash {
+	acct := s.account(addr)
+	if value, ok := acct.committed[slot]; ok {
+		return value
+	}
+	if acct.cleared {
+		return common.Hash{}
+	}
+	value, err := s.src.Storage(addr, slot)
+	if err != nil {
+		s.fail(err)
+	}
+	acct.committed[slot] = value
+	return value
+}
+
+func (s *remoteState) GetState(addr common.Address, slot common.Hash) common.Hash {
+	if value, ok := s.account(addr).dirty[slot]; ok {
+		return value
+	}
+	return s.GetCommittedState(addr, slot)
+}
+
+func (s *remoteState) SetState(addr common.Address, slot, value common.Hash) {
+	acct := s.account(addr)
+	prev, had := acct.dirty[slot]
+	acct.dirty[slot] = value
+	s.journal = append(s.journal, func() {
+		if had {
+			acct.dirty[slot] = prev
+		} else {
+			delete(acct.dirty, slot)
+		}
+	})
+}
+
+// applyOverrides applies overrides the way eth_call does: State replaces all
+// of an account's storage and StateDiff only the given slots.
+func (s *remoteState) applyOverrides(overrides stateOverrides) {
+	for addr, o := range overrides {
+		acct := s.account(addr)
+		acct.exists = true
+		if o.Balance != nil {
+			acct.balance = new(big.Int).Set(o.Balance.ToInt())
+		}
+		if o.Nonce != nil {
+			acct.nonce = uint64(*o.Nonce)
+		}
+		if o.Code != nil {
+			acct.code = *o.Code
+		}
+		if o.State != nil {
+			acct.cleared = true
+			acct.committed = map[common.Hash]common.Hash{}
+		}
+		for slot, value := range o.State {
+			acct.committed[slot] = value
+		}
+		for slot, value := range o.StateDiff {
+			acct.committed[slot] = value
+		}
+	}
+}
+
+// override returns the state of addr as an override for eth_call: its
+// balance, nonce, code and the storage read or written. Storage replaces that
+// of the node if the account was recreated.
+func (s *remoteState) override(addr common.Address) stateOverride {
+	acct := s.account(addr)
+	balance, nonce, code := hexutil.Big(*acct.balance), hexutil.Uint64(acct.nonce), hexutil.Bytes(acct.code)
+	o := stateOverride{Balance: &balance, Nonce: &nonce, Code: &code}
+	slots := map[common.Hash]common.Hash{}
+	for slot, value := range acct.committed {
+		slots[slot] = value
+	}
+	for slot, value := range acct.dirty {
+		slots[slot] = value
+	}
+	if acct.cleared {
+		o.State = slots
+	} else {
+		o.StateDiff = slots
+	}
+	return o
+}
+
+func (s *remoteState) Suicide(addr common.Address) bool {
+	acct := s.account(addr)
+	if !acct.exists {
+		return false
+	}
+	prevSuicided, prevBalance := acct.suicided, acct.balance
+	acct.suicided, acct.balance = true, new(big.Int)
+	s.journal = append(s.journal, func() { acct.suicided, acct.balance = prevSuicided, prevBalance })
+	return true
+}
+
+func (s *remoteState) HasSuicided(addr common.Address) bool {
+	return s.account(addr).suicided
+}
+
+func (s *remoteState) Exist(addr common.Address) bool {
+	return s.account(addr).exists
+}
+
+func (s *remoteState) Empty(addr common.Address) bool {
+	acct := s.account(addr)
+	return acct.balance.Sign() == 0 && acct.nonce == 0 && len(acct.code) == 0
+}
+
+func (s *remoteState) PrepareAccessList(sender common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList) {
+	s.AddAddressToAccessList(sender)
+	if dest != nil {
+		s.AddAddressToAccessList(*dest)
+	}
+	for _, addr := range precompiles {
+		s.AddAddressToAccessList(addr)
+	}
+	for _, tuple := range txAccesses {
+		s.AddAddressToAccessList(tuple.Address)
+		for _, slot := range tuple.StorageKeys {
+			s.AddSlotToAccessList(tuple.Address, slot)
+		}
+	}
+}
+
+func (s *remoteState) AddressInAccessList(addr common.Address) bool {
+	return s.accessAddrs[addr]
+}
+
+func (s *remoteState) SlotInAccessList(addr common.Address, slot common.Hash) (bool, bool) {
+	return s.accessAddrs[addr], s.accessSlots[addr][slot]
+}
+
+func (s *remoteState) AddAddressToAccessList(addr common.Address) {
+	if s.accessAddrs[addr] {
+		return
+	}
+	s.accessAddrs[addr] = true
+	s.journal = append(s.journal, func() { delete(s.accessAddrs, addr) })
+}
+
+func (s *remoteState) AddSlotToAccessList(addr common.Address, slot common.Hash) {
+	s.AddAddressToAccessList(addr)
+	if s.accessSlots[addr] == nil {
+		s.accessSlots[addr] = map[common.Hash]bool{}
+	}
+	if s.accessSlots[addr][slot] {
+		return
+	}
+	s.accessSlots[addr][slot] = true
+	s.journal = append(s.journal, func() { delete(s.accessSlots[addr], slot) })
+}
+
+func (s *remoteState) Snapshot() int {
+	return len(s.journal)
+}
+
+func (s *remoteState) RevertToSnapshot(id int) {
+	for i := len(s.journal) - 1; i >= id; i-- {
+		s.journal[i]()
+	}
+	s.journal = s.journal[:id]
+}
+
+func (s *remoteState) AddLog(l *types.Log) {
+	s.logs = append(s.logs, l)
+	n := len(s.logs) - 1
+	s.journal = append(s.journal, func() { s.logs = s.logs[:n] })
+}
+
+func (s *remoteState) AddPreimage(common.Hash, []byte) {}
+
+// ForEachStorage only visits the slots changed during execution.
+func (s *remoteState) ForEachStorage(addr common.Address, cb func(common.Hash, common.Hash) bool) error {
+	for slot, value := range s.account(addr).dirty {
+		if !cb(slot, value) {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ERC-4337 reputation parameters.
+const (
+	minInclusionRateDenominator = 10
+	throttlingSlack             = 10
+	banSlack                    = 50
+	// throttledEntityMempoolCount is how many ops of a throttled entity may
+	// be in the mempool at once.
+	throttledEntityMempoolCount = 4
+	// throttledEntityBundleCount is how many ops of a throttled entity may be
+	// in one bundle.
+	throttledEntityBundleCount = 4
+	// crashedOpsSeen is the ops seen count of an entity that made handleOps
+	// revert, which bans it for about three days.
+	crashedOpsSeen = 10000
+)
+
+type reputationStatus int
+
+const (
+	statusOK reputationStatus = iota
+	statusThrottled
+	statusBanned
+)
+
+func (s reputationStatus) String() string {
+	switch s {
+	case statusThrottled:
+		return "THROTTLED"
+	case statusBanned:
+		return "BANNED"
+	}
+	return "OK"
+}
+
+type reputationEntry struct {
+	OpsSeen     uint64 `json:"opsSeen"`
+	OpsIncluded uint64 `json:"opsIncluded"`
+}
+
+func (r *reputationEntry) status() reputationStatus {
+	maxSeen := r.OpsSeen / minInclusionRateDenominator
+	switch {
+	case maxSeen <= r.OpsIncluded+throttlingSlack:
+		return statusOK
+	case maxSeen <= r.OpsIncluded+banSlack:
+		return statusThrottled
+	}
+	return statusBanned
+}
+
+// reputationManager tracks how many ops of each paymaster, factory and
+// aggregator were seen and how many of them made it on chain. It is persisted
+// to a JSON file so reputation survives restarts.
+type reputationManager struct {
+	mu      sync.Mutex
+	entries map[common.Address]*reputationEntry
+	path    string
+	dirty   bool
+}
+
+// loadReputation reads the reputation file at path. A missing file starts
+// with an empty reputation.
+func loadReputation(path string) (*reputationManager, error) {
+	m := &reputationManager{entries: map[common.Address]*reputationEntry{}, path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.entries); err != nil {
+		return nil, fmt.Errorf("invalid reputation file %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *reputationManager) entry(addr common.Address) *reputationEntry {
+	r, ok := m.entries[addr]
+	if !ok {
+		r = &reputationEntry{}
+		m.entries[addr] = r
+	}
+	return r
+}
+
+// Seen records that a valid op using each of addrs entered the mempool.
+func (m *reputationManager) Seen(addrs ...common.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, addr := range addrs {
+		m.entry(addr).OpsSeen++
+	}
+	m.dirty = len(addrs) > 0 || m.dirty
+}
+
+// Included records that an op using each of addrs was included on chain.
+func (m *reputationManager) Included(addrs ...common.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, addr := range addrs {
+		m.entry(addr).OpsIncluded++
+	}
+	m.dirty = len(addrs) > 0 || m.dirty
+}
+
+// Crashed records that addr made a bundle revert on chain simulation although
+// its op passed validation. That bans it until its reputation decays.
+func (m *reputationManager) Crashed(addr common.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.entry(addr)
+	r.OpsSeen, r.OpsIncluded = crashedOpsSeen, 0
+	m.dirty = true
+}
+
+func (m *reputationManager) Status(addr common.Address) reputationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.entries[addr]
+	if !ok {
+		return statusOK
+	}
+	return r.status()
+}
+
+// decay scales every counter by 23/24. Called once an hour.
+func (m *reputationManager) decay() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, r := range m.entries {
+		r.OpsSeen = r.OpsSeen * 23 / 24
+		r.OpsIncluded = r.OpsIncluded * 23 / 24
+		if r.OpsSeen == 0 && r.OpsIncluded == 0 {
+			delete(m.entries, addr)
+		}
+	}
+	m.dirty = true
+}
+
+// Save writes the reputation file if anything changed since the last save.
+func (m *reputationManager) Save() error {
+	m.mu.Lock()
+	if !m.dirty || m.path == "" {
+		m.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(m.entries, "", "  ")
+	m.dirty = false
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err == nil {
+		err = os.Rename(tmp, m.path)
+	}
+	if err != nil {
+		m.mu.Lock()
+		m.dirty = true
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// run decays the reputation every hour and saves it every minute.
+func (m *reputationManager) run() {
+	decay := time.NewTicker(time.Hour)
+	save := time.NewTicker(time.Minute)
+	for {
+		select {
+		case <-decay.C:
+			m.decay()
+		case <-save.C:
+		}
+		if err := m.Save(); err != nil {
+			log.Error("failed to save reputation", "path", m.path, "error", err)
+		}
+	}
+}
+
+// saveReputationOnExit saves the reputation of every chain when the process
+// is interrupted or terminated.
+func saveReputationOnExit(reps []*reputationManager) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	for _, reputation := range reps {
+		if err := reputation.Save(); err != nil {
+			log.Error("failed to save reputation", "path", reputation.path, "error", err)
+		}
+	}
+	os.Exit(0)
+}
+
+// checkReputation rejects ops that use a banned entity, or a throttled entity
+// that already has throttledEntityMempoolCount ops in the mempool.
+func checkReputation(reputation *reputationManager, pool *mempool, entities validationEntities) *RPCError {
+	for _, addr := range entities.reputationEntities() {
+		switch reputation.Status(addr) {
+		case statusBanned:
+			return reputationError(addr, statusBanned)
+		case statusThrottled:
+			if pool.CountEntity(addr) >= throttledEntityMempoolCount {
+				return reputationError(addr, statusThrottled)
+			}
+		}
+	}
+	return nil
+}
+
+func reputationError(addr common.Address, status reputationStatus) *RPCError {
+	return &RPCError{
+		Code:    e.JsonRpcEntityBannedOrThrottled,
+		Message: fmt.Sprintf("entity %s is %s", addr, status),
+		Data: map[string]interface{}{
+			"entity": addr,
+			"status": status.String(),
+		},
+	}
+}
+
+// reputationEntities returns the entities whose reputation is tracked.
+func (v validationEntities) reputationEntities() []common.Address {
+	var addrs []common.Address
+	for _, addr := range []common.Address{v.Paymaster, v.Factory, v.Aggregator} {
+		if addr != zeroAddress {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
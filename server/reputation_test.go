@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestReputation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reputation.json")
+	m, err := loadReputation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymaster := common.HexToAddress("0xbb")
+
+	for i := 0; i < 100; i++ {
+		m.Seen(paymaster)
+	}
+	if status := m.Status(paymaster); status != statusOK {
+		t.Errorf("expected OK after 100 seen, got %v", status)
+	}
+	for i := 0; i < 300; i++ {
+		m.Seen(paymaster)
+	}
+	if status := m.Status(paymaster); status != statusThrottled {
+		t.Errorf("expected THROTTLED after 400 seen, got %v", status)
+	}
+	for i := 0; i < 400; i++ {
+		m.Seen(paymaster)
+	}
+	if status := m.Status(paymaster); status != statusBanned {
+		t.Errorf("expected BANNED after 800 seen, got %v", status)
+	}
+	for i := 0; i < 70; i++ {
+		m.Included(paymaster)
+	}
+	if status := m.Status(paymaster); status != statusOK {
+		t.Errorf("expected OK after 70 included, got %v", status)
+	}
+
+	m.decay()
+	if r := m.entries[paymaster]; r.OpsSeen != 766 || r.OpsIncluded != 67 {
+		t.Errorf("unexpected counters after decay: %+v", *r)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadReputation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := loaded.entries[paymaster]; r == nil || *r != *m.entries[paymaster] {
+		t.Errorf("reputation not persisted: %+v", r)
+	}
+}
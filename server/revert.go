@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// entryPointErrorsABI holds the errors EntryPoint v0.7 adds to those of v0.6
+// in EntryPoint.go.
+const entryPointErrorsABI = `[
+	{"type": "error", "name": "FailedOpWithRevert", "inputs": [
+		{"name": "opIndex", "type": "uint256"},
+		{"name": "reason", "type": "string"},
+		{"name": "inner", "type": "bytes"}
+	]}
+]`
+
+var (
+	errorSelector = [4]byte{0x08, 0xc3, 0x79, 0xa0} // Error(string)
+	panicSelector = [4]byte{0x4e, 0x48, 0x7b, 0x71} // Panic(uint256)
+)
+
+// panicReasons describes the Solidity panic codes.
+var panicReasons = map[uint64]string{
+	0x01: "assertion failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to an uninitialized function",
+}
+
+// revertRegistry maps error selectors to the custom errors they decode with:
+// the EntryPoint's errors, plus those of the ABIs in ABI_DIR.
+type revertRegistry struct {
+	mu     sync.RWMutex
+	errors map[[4]byte]abi.Error
+}
+
+var revertErrors = func() *revertRegistry {
+	r := &revertRegistry{errors: map[[4]byte]abi.Error{}}
+	for _, parse := range []func() (*abi.ABI, error){
+		EntryPointMetaData.GetAbi,
+		func() (*abi.ABI, error) {
+			parsed, err := abi.JSON(strings.NewReader(entryPointErrorsABI))
+			return &parsed, err
+		},
+	} {
+		parsed, err := parse()
+		if err != nil {
+			panic(err)
+		}
+		r.add(parsed)
+	}
+	return r
+}()
+
+func (r *revertRegistry) add(parsed *abi.ABI) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, abiErr := range parsed.Errors {
+		var id [4]byte
+		copy(id[:], abiErr.ID[:4])
+		r.errors[id] = abiErr
+	}
+}
+
+func (r *revertRegistry) lookup(data []byte) (abi.Error, bool) {
+	var id [4]byte
+	copy(id[:], data)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	abiErr, ok := r.errors[id]
+	return abiErr, ok
+}
+
+// loadRevertABIs adds the errors of every *.json ABI in dir to the registry.
+// Both plain ABI arrays and artifacts with an "abi" field are accepted.
+func loadRevertABIs(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var artifact struct {
+			ABI json.RawMessage `json:"abi"`
+		}
+		if json.Unmarshal(data, &artifact) == nil && len(artifact.ABI) > 0 {
+			data = artifact.ABI
+		}
+		parsed, err := abi.JSON(strings.NewReader(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid ABI %s: %w", file, err)
+		}
+		revertErrors.add(&parsed)
+	}
+	return nil
+}
+
+// revertReason is decoded revert data.
+type revertReason struct {
+	// Error is the name of the error, empty if the data could not be decoded.
+	Error  string
+	Reason string
+	// OpIndex is set for FailedOp errors.
+	OpIndex *big.Int
+	// Entity is the entity that failed, if the error tells.
+	Entity string
+	// Args are the arguments of custom errors.
+	Args  map[string]interface{}
+	Inner *revertReason
+	Data  hexutil.Bytes
+}
+
+// decodeRevert decodes revert data as FailedOp, Error(string), Panic(uint256)
+// or a registered custom error.
+func decodeRevert(data []byte) *revertReason {
+	r := &revertReason{Data: data, Reason: hexutil.Encode(data)}
+	if len(data) < 4 {
+		return r
+	}
+	var id [4]byte
+	copy(id[:], data)
+	switch id {
+	case errorSelector:
+		if reason, err := abi.UnpackRevert(data); err == nil {
+			r.Error, r.Reason = "Error", reason
+		}
+		return r
+	case panicSelector:
+		if len(data) == 4+32 {
+			code := new(big.Int).SetBytes(data[4:])
+			r.Error, r.Reason = "Panic", fmt.Sprintf("panic 0x%x", code)
+			if desc, ok := panicReasons[code.Uint64()]; code.IsUint64() && ok {
+				r.Reason += ": " + desc
+			}
+		}
+		return r
+	}
+	abiErr, ok := revertErrors.lookup(data)
+	if !ok {
+		return r
+	}
+	values, err := abiErr.Inputs.Unpack(data[4:])
+	if err != nil {
+		return r
+	}
+	r.Error = abiErr.Name
+	r.Args = map[string]interface{}{}
+	for i, input := range abiErr.Inputs {
+		name := input.Name
+		if name == "" {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		r.Args[name] = values[i]
+	}
+	switch abiErr.Name {
+	case "FailedOp", "FailedOpWithRevert":
+		r.OpIndex, _ = r.Args["opIndex"].(*big.Int)
+		r.Reason, _ = r.Args["reason"].(string)
+		r.Entity = failedOpEntity(r.Reason)
+		if inner, ok := r.Args["inner"].([]byte); ok && len(inner) > 0 {
+			r.Inner = decodeRevert(inner)
+		}
+		r.Args = nil
+	default:
+		r.Reason = fmt.Sprintf("%s%v", abiErr.Name, values)
+	}
+	return r
+}
+
+// failedOpEntity returns the entity an EntryPoint "AAxx" reason blames.
+func failedOpEntity(reason string) string {
+	switch {
+	case strings.HasPrefix(reason, "AA1"):
+		return entityFactory
+	case strings.HasPrefix(reason, "AA2"):
+		return entityAccount
+	case strings.HasPrefix(reason, "AA3"):
+		return entityPaymaster
+	}
+	return ""
+}
+
+func (r *revertReason) String() string {
+	if r.Inner != nil {
+		return r.Reason + ": " + r.Inner.String()
+	}
+	return r.Reason
+}
+
+// rpcData returns r as RPC error data.
+func (r *revertReason) rpcData() map[string]interface{} {
+	data := map[string]interface{}{
+		"reason": r.Reason,
+		"revert": r.Data,
+	}
+	if r.Error != "" {
+		data["error"] = r.Error
+	}
+	if r.OpIndex != nil {
+		data["opIndex"] = r.OpIndex
+	}
+	if r.Entity != "" {
+		data["entity"] = r.Entity
+	}
+	if len(r.Args) > 0 {
+		data["args"] = r.Args
+	}
+	if r.Inner != nil {
+		data["inner"] = r.Inner.rpcData()
+	}
+	return data
+}
+
+// revertError returns err of a call to what as an RPC error, with the decoded
+// revert in its data if err carries one.
+func revertError(code int, what string, err error) *RPCError {
+	data, ok := revertData(err)
+	if !ok {
+		return &RPCError{Code: code, Message: fmt.Sprintf("%s failed: %v", what, err)}
+	}
+	reason := decodeRevert(data)
+	return &RPCError{
+		Code:    code,
+		Message: fmt.Sprintf("%s reverted: %s", what, reason),
+		Data:    reason.rpcData(),
+	}
+}
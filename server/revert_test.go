@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func packError(t *testing.T, abiErr abi.Error, args ...interface{}) []byte {
+	t.Helper()
+	packed, err := abiErr.Inputs.Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(abiErr.ID[:4:4], packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	dir := t.TempDir()
+	artifact := `{"abi": [{"type": "error", "name": "InvalidNonce", "inputs": [{"name": "nonce", "type": "uint256"}]}]}`
+	if err := os.WriteFile(filepath.Join(dir, "Account.json"), []byte(artifact), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadRevertABIs(dir); err != nil {
+		t.Fatal(err)
+	}
+	custom := append(crypto.Keccak256([]byte("InvalidNonce(uint256)"))[:4], common.BigToHash(big.NewInt(7)).Bytes()...)
+
+	parsed, err := abi.JSON(strings.NewReader(entryPointErrorsABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failedOp := packError(t, parsed.Errors["FailedOpWithRevert"], big.NewInt(2), "AA23 reverted", custom)
+
+	r := decodeRevert(failedOp)
+	if r.Error != "FailedOpWithRevert" || r.OpIndex.Int64() != 2 || r.Entity != entityAccount {
+		t.Fatalf("unexpected FailedOpWithRevert %+v", r)
+	}
+	if r.Inner == nil || r.Inner.Error != "InvalidNonce" || r.Inner.Args["nonce"].(*big.Int).Int64() != 7 {
+		t.Fatalf("unexpected inner revert %+v", r.Inner)
+	}
+
+	panicData := append([]byte{0x4e, 0x48, 0x7b, 0x71}, common.BigToHash(big.NewInt(0x11)).Bytes()...)
+	if r := decodeRevert(panicData); r.Reason != "panic 0x11: arithmetic overflow or underflow" {
+		t.Fatalf("unexpected panic reason %q", r.Reason)
+	}
+	if r := decodeRevert([]byte{1, 2, 3, 4, 5}); r.Error != "" || r.Reason != "0x0102030405" {
+		t.Fatalf("unknown revert decoded as %+v", r)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+)
+
+// senderCreatorABI is the SenderCreator of EntryPoint v0.6 and v0.7, which
+// runs the initCode of ops. The EntryPoint deploys it in its constructor.
+const senderCreatorABI = `[
+	{"type": "function", "name": "createSender", "inputs": [
+		{"name": "initCode", "type": "bytes"}
+	], "outputs": [
+		{"name": "sender", "type": "address"}
+	]}
+]`
+
+var senderCreatorCalls = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(senderCreatorABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// createSender runs initCode in evm through the SenderCreator of ep with gas,
+// as the EntryPoint does before validating an op. It returns the address the
+// factory returned, zero if it failed, and the gas used.
+func createSender(evm *vm.EVM, ep common.Address, initCode []byte, gas uint64) (common.Address, uint64, error) {
+	data, err := senderCreatorCalls.Pack("createSender", initCode)
+	if err != nil {
+		return zeroAddress, 0, err
+	}
+	ret, left, err := evm.Call(vm.AccountRef(ep), crypto.CreateAddress(ep, 1), data, gas, new(big.Int))
+	var sender common.Address
+	if err == nil {
+		err = senderCreatorCalls.UnpackIntoInterface(&sender, "createSender", ret)
+	}
+	if err != nil {
+		return zeroAddress, gas - left, nil
+	}
+	return sender, gas - left, nil
+}
+
+// getSenderAddress returns the address the factory in initCode deploys the
+// account to, by calling getSenderAddress with eth_call. It reverts with
+// SenderAddressResult on both v0.6 and v0.7, other reverts are returned as an
+// *RPCError.
+func getSenderAddress(conn *ethclient.Client, ep common.Address, initCode []byte) (common.Address, *RPCError, error) {
+	epABI, err := EntryPointMetaData.GetAbi()
+	if err != nil {
+		return zeroAddress, nil, err
+	}
+	data, err := epABI.Pack("getSenderAddress", initCode)
+	if err != nil {
+		return zeroAddress, nil, err
+	}
+	out, err := conn.CallContract(context.Background(), ethereum.CallMsg{
+		From: zeroAddress,
+		To:   &ep,
+		Gas:  simulationGasLimit,
+		Data: data,
+	}, nil)
+	if err != nil {
+		revert, ok := revertData(err)
+		if !ok {
+			return zeroAddress, nil, err
+		}
+		senderAddressResult := epABI.Errors["SenderAddressResult"]
+		if len(revert) < 4 || !bytes.Equal(revert[:4], senderAddressResult.ID[:4]) {
+			return zeroAddress, revertError(e.JsonRpcRejectedByEntryPointOrAccount, "getSenderAddress", err), nil
+		}
+		values, err := senderAddressResult.Unpack(revert)
+		if err != nil {
+			return zeroAddress, nil, err
+		}
+		return values.([]interface{})[0].(common.Address), nil, nil
+	}
+	return zeroAddress, nil, fmt.Errorf("getSenderAddress of %s returned %s instead of reverting", ep, hexutil.Bytes(out))
+}
+
+// checkSenderAddress rejects ops with initCode that deploys to another address
+// than the sender.
+func checkSenderAddress(conn *ethclient.Client, ep common.Address, op _UserOperation) (*RPCError, error) {
+	if len(op.InitCode) == 0 {
+		return nil, nil
+	}
+	sender, rpcErr, err := getSenderAddress(conn, ep, op.InitCode)
+	if rpcErr != nil || err != nil {
+		return rpcErr, err
+	}
+	if sender == op.Sender {
+		return nil, nil
+	}
+	return &RPCError{
+		Code:    e.JsonRpcRejectedByEntryPointOrAccount,
+		Message: fmt.Sprintf("initCode deploys to %s, not the sender %s", sender, op.Sender),
+		Data: map[string]interface{}{
+			"entity":         entityFactory,
+			"sender":         op.Sender,
+			"expectedSender": sender,
+		},
+	}, nil
+}
+
+// SenderAddressRequest is a bundler_getSenderAddress request with params
+// [initCode, entryPoint].
+type SenderAddressRequest struct {
+	Jsonrpc string            `json:"jsonrpc"`
+	Id      *big.Int          `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// handle_bundler_getSenderAddress returns the counterfactual address of the
+// account deployed by initCode.
+func (b *bundler) handle_bundler_getSenderAddress(respw http.ResponseWriter, req *http.Request) {
+	respw.Header().Set("Content-Type", "application/json")
+	respw.WriteHeader(200)
+	var r SenderAddressRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(r.Params) != 2 {
+		http.Error(respw, "invalid number of params for bundler_getSenderAddress", e.JsonRpcInvalidParams)
+		return
+	}
+	var initCode hexutil.Bytes
+	var entryPoint common.Address
+	if err := json.Unmarshal(r.Params[0], &initCode); err != nil {
+		http.Error(respw, "invalid initCode: "+err.Error(), e.JsonRpcInvalidParams)
+		return
+	}
+	if err := json.Unmarshal(r.Params[1], &entryPoint); err != nil {
+		http.Error(respw, "invalid entryPoint: "+err.Error(), e.JsonRpcInvalidParams)
+		return
+	}
+	if !b.checkSafeEntryPoint(UserOperationWithEntryPoint{EntryPoint: entryPoint}) {
+		http.Error(respw, "Entry point not safe,", e.JsonRpcInvalidParams)
+		return
+	}
+	if _, _, err := splitEntityField("initCode", initCode); err != nil || len(initCode) == 0 {
+		http.Error(respw, "initCode must start with the factory address", e.JsonRpcInvalidParams)
+		return
+	}
+	sender, rpcErr, err := getSenderAddress(b.nodes.Eth(), entryPoint, initCode)
+	if err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInternalError)
+		return
+	}
+	if rpcErr != nil {
+		json.NewEncoder(respw).Encode(NewRPCError(r.Id, rpcErr))
+		return
+	}
+	json.NewEncoder(respw).Encode(NewRPCResult(r.Id, sender))
+}
@@ -1,259 +1,356 @@
-package main
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"os"
-
-	// "go/types"
-
-	e "flashbotsAAbundler/consts"
-	"math/big"
-	"net/http"
-
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/consensus/misc"
-	ethclient "github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/log"
-	"github.com/ethereum/go-ethereum/params"
-	"github.com/joho/godotenv"
-)
-
-var (
-	safeEntryPoints = []common.Address{
-		common.HexToAddress("0x2777be7bc3871cfba57ccdb522fa2bfb94cdd209"), //goerli
-	}
-	zeroAddress = common.HexToAddress("0x0000000000000000000000000000000000000000")
-)
-
-type UserOperationJSON struct {
-	UserOperation _UserOperation `json:"userOperation"`
-	EntryPoint    common.Address `json:"entryPoint"`
-}
-
-type _UserOperation struct {
-	Sender               common.Address `json:"sender"`
-	Nonce                *big.Int       `json:"nonce"`
-	InitCode             string         `json:"initCode"`
-	CallData             string         `json:"callData"`
-	CallGasLimit         *big.Int       `json:"callGasLimit"`
-	VerificationGasLimit *big.Int       `json:"verificationGasLimit"`
-	PreVerificationGas   *big.Int       `json:"preVerificationGas"`
-	MaxFeePerGas         *big.Int       `json:"maxFeePerGas"`
-	MaxPriorityFeePerGas *big.Int       `json:"maxPriorityFeePerGas"`
-	PaymasterAndData     string         `json:"paymasterAndData"` //paymasterAndData holds the paymaster address followed by the token address to use.
-	Signature            string         `json:"signature"`
-}
-
-type UserOperationWithEntryPoint struct {
-	UserOperation _UserOperation `json:"params"`
-	EntryPoint    common.Address `json:"entryPoint"`
-}
-
-type Request struct { //from EIP
-	Jsonrpc string              `json:"jsonrpc"`
-	Id      *big.Int            `json:"id"`
-	Method  string              `json:"method"`
-	Params  []UserOperationJSON `json:"params"` //1st User op, 2nd entry point
-}
-type Response struct {
-	Jsonrpc string   `json:"jsonrpc"`
-	Id      *big.Int `json:"id"`
-	Result  Result   `json:"Result"`
-}
-type Result struct {
-	Success bool
-	TxHash  common.Hash
-}
-
-func main() {
-	envErr := godotenv.Load(".env")
-	if envErr != nil {
-		fmt.Printf("Error loading .env file")
-		os.Exit(1)
-	}
-	http.HandleFunc("/eth_sendUserOperation", handle_eth_sendUserOperation)
-	http.HandleFunc("/eth_supportedEntryPoints", handle_eth_supportedEntryPoints)
-	if err := http.ListenAndServe(":8080", nil); err != nil { //listens for http reqs on 8080
-		log.Error("http server failed", "error", err)
-	}
-
-}
-
-func handle_eth_sendUserOperation(respw http.ResponseWriter, req *http.Request) {
-	respw.Header().Set("Content-Type", "application/json")
-	respw.WriteHeader(200)
-	//copying the params of the call to a type userOperationWithEntryPoint struct for ease in sanity checks
-	var r Request
-	err := json.NewDecoder(req.Body).Decode(&r)
-	if err != nil {
-		http.Error(respw, err.Error(), http.StatusBadRequest)
-		return
-	}
-	UopwithEP := NewTypeUserOperation(r.Params[0])
-	// Checking for safe Entry Point
-	if !checkSafeEntryPoint(UopwithEP) {
-		http.Error(respw, "Entry point not safe,", e.JsonRpcInvalidParams)
-		return
-	}
-	//basic sanity checks
-	//1. Check the length of params
-	if len(r.Params) != 1 {
-		http.Error(respw, "invalid number of params for eth_sendUserOperation", e.JsonRpcInvalidParams)
-		return
-	}
-
-	//2. Either the sender is an existing contract, or the initCode is not empty (but not both)
-
-	senderCheck, err := addressHasCode(UopwithEP.UserOperation.Sender)
-	if err != nil {
-		http.Error(respw, err.Error(), e.JsonRpcInternalError) //error type not sure
-		return
-	}
-
-	if !senderCheck && UopwithEP.UserOperation.InitCode == "" {
-		http.Error(respw, "neither sender nor initcode available", e.JsonRpcInvalidParams)
-		return
-	}
-
-	if senderCheck && UopwithEP.UserOperation.InitCode != "" {
-		http.Error(respw, "cant take wallet as well as InitCode", e.JsonRpcInvalidParams)
-		return
-	}
-
-	//3. Verification gas is sufficiently low
-	max_verification_gas := big.NewInt(100e9) //from kristof's mev searcher bot. Needs optimization
-	if UopwithEP.UserOperation.VerificationGasLimit.Cmp(max_verification_gas) > 0 {
-		http.Error(respw, "verification gas higher than max_verification_gas", e.JsonRpcInvalidParams)
-		return
-	}
-	//4.preVerification gas is sufficiently high
-	sum := big.NewInt(0)
-	sum.Add(UopwithEP.UserOperation.CallGasLimit, UopwithEP.UserOperation.VerificationGasLimit)
-	if UopwithEP.UserOperation.PreVerificationGas.Cmp(sum) < 0 {
-		http.Error(respw, "PreVerificationGas is not high enough", e.JsonRpcInvalidParams)
-	}
-
-	//5. Paymaster is either zero address or contract with non zero code, registered and staked, sufficient deposit and not blacklisted
-	paymaster := getPaymaster(UopwithEP.UserOperation)
-	//TODO need to have a db to handle registered paymasters and blacklisted paymasters
-	paymasterCheck, err := addressHasCode(paymaster)
-	if err != nil {
-		http.Error(respw, "error while getting code from sender address", e.JsonRpcInternalError) //error type not confirmed
-		return
-	}
-	if !(paymasterCheck || paymaster == zeroAddress) {
-		http.Error(respw, "paymaster not contract or zero address", e.JsonRpcInvalidParams)
-		return
-	}
-
-	//6. maxFeePerGas and maxPriorityFeeGas are greater or equal than block's basefee
-	currBaseFee, err := getCurrentBlockBasefee()
-	if err != nil {
-		http.Error(respw, "failed to get block basefee", e.JsonRpcInternalError)
-	}
-	if !(UopwithEP.UserOperation.MaxFeePerGas.Cmp(currBaseFee) > 0) { //
-		http.Error(respw, "Max fee per gas too low ", e.JsonRpcInvalidParams)
-		return
-	}
-	OneGwei := big.NewInt(1000000000)
-	if !(UopwithEP.UserOperation.MaxPriorityFeePerGas.Cmp(OneGwei) > 0) {
-		http.Error(respw, "Priority fee per gas too low", e.JsonRpcInvalidParams)
-		return
-	}
-
-	//TODO-7. Sender does not have another user op already in the pool. if that is the case the new tx should have +1 nonce
-	// simulateValidation
-	simSuccess, err := UopwithEP.UserOperation.simValidation()
-	fmt.Println("Sim valid success: ", simSuccess, "error: ", err.Error())
-	if err != nil {
-		http.Error(respw, "Sim validation failed", e.JsonRpcTransactionError)
-		return
-	}
-	// calling handleOps function
-	success, tx, err := UopwithEP.UserOperation.CallHandleOps()
-	fmt.Println(err)
-	if err != nil {
-		http.Error(respw, "Handle Ops Call failed", e.JsonRpcTransactionError)
-		resData := r.WriteRPCResponse(success, common.HexToHash("0"))
-		json.NewEncoder(respw).Encode(resData)
-		return
-	}
-	//write json response
-	resData := r.WriteRPCResponse(success, tx.Hash())
-	json.NewEncoder(respw).Encode(resData)
-
-}
-
-func NewTypeUserOperation(json UserOperationJSON) UserOperationWithEntryPoint {
-	return UserOperationWithEntryPoint{
-		UserOperation: _UserOperation{
-			Sender:               json.UserOperation.Sender,
-			Nonce:                json.UserOperation.Nonce,
-			InitCode:             json.UserOperation.InitCode,
-			CallData:             json.UserOperation.CallData,
-			CallGasLimit:         json.UserOperation.CallGasLimit,
-			VerificationGasLimit: json.UserOperation.VerificationGasLimit,
-			PreVerificationGas:   json.UserOperation.PreVerificationGas,
-			MaxFeePerGas:         json.UserOperation.MaxFeePerGas,
-			MaxPriorityFeePerGas: json.UserOperation.MaxPriorityFeePerGas,
-			PaymasterAndData:     json.UserOperation.PaymasterAndData,
-			Signature:            json.UserOperation.Signature,
-		},
-		EntryPoint: json.EntryPoint,
-	}
-}
-
-func handle_eth_supportedEntryPoints(respw http.ResponseWriter, req *http.Request) {
-	respw.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(respw, safeEntryPoints[0].String())
-}
-
-func checkSafeEntryPoint(s UserOperationWithEntryPoint) bool {
-	ep := s.EntryPoint
-	for _, safeAddress := range safeEntryPoints {
-		if ep == safeAddress {
-			return true
-		}
-	}
-	return false
-}
-
-func getCurrentBlockBasefee() (*big.Int, error) {
-	config := params.GoerliChainConfig //needs to be changed to mainnet config
-	ethClient, _ := ethclient.DialContext(context.Background(), getClient())
-	bn, _ := ethClient.BlockNumber(context.Background())
-	bignumBn := big.NewInt(0).SetUint64(bn)
-	blk, err := ethClient.BlockByNumber(context.Background(), bignumBn)
-	if err != nil {
-		return big.NewInt(0), err
-	}
-	baseFee := misc.CalcBaseFee(config, blk.Header())
-	return baseFee, nil
-}
-
-func getClient() string {
-	return os.Getenv("CLIENT")
-}
-
-func getPaymaster(uop _UserOperation) common.Address {
-	return zeroAddress //temporary
-}
-
-func addressHasCode(addy common.Address) (bool, error) { //for wallet as well as paymaster
-	conn, err := ethclient.Dial(getClient())
-	if err != nil {
-		return false, err
-	}
-	ctx := context.Background()
-	code, err := conn.CodeAt(ctx, addy, nil)
-	if err != nil {
-		return false, err
-	}
-	if code != nil {
-		return true, nil
-	} else {
-		return false, nil
-	}
-}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	// "go/types"
+
+	e "flashbotsAAbundler/consts"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/exp"
+)
+
+var (
+	zeroAddress = common.HexToAddress("0x0000000000000000000000000000000000000000")
+)
+
+type UserOperationJSON struct {
+	UserOperation _UserOperation `json:"userOperation"`
+	EntryPoint    common.Address `json:"entryPoint"`
+}
+
+type _UserOperation struct {
+	Sender               common.Address `json:"sender"`
+	Nonce                *big.Int       `json:"nonce"`
+	InitCode             hexutil.Bytes  `json:"initCode"`
+	CallData             hexutil.Bytes  `json:"callData"`
+	CallGasLimit         *big.Int       `json:"callGasLimit"`
+	VerificationGasLimit *big.Int       `json:"verificationGasLimit"`
+	PreVerificationGas   *big.Int       `json:"preVerificationGas"`
+	MaxFeePerGas         *big.Int       `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *big.Int       `json:"maxPriorityFeePerGas"`
+	PaymasterAndData     hexutil.Bytes  `json:"paymasterAndData"` //paymasterAndData holds the paymaster address followed by the token address to use.
+	Signature            hexutil.Bytes  `json:"signature"`
+
+	// The paymaster gas limits of EntryPoint v0.7 ops, nil for v0.6 ops.
+	PaymasterVerificationGasLimit *big.Int `json:"paymasterVerificationGasLimit,omitempty"`
+	PaymasterPostOpGasLimit       *big.Int `json:"paymasterPostOpGasLimit,omitempty"`
+}
+
+type UserOperationWithEntryPoint struct {
+	UserOperation _UserOperation `json:"params"`
+	EntryPoint    common.Address `json:"entryPoint"`
+}
+
+type Request struct { //from EIP
+	Jsonrpc string              `json:"jsonrpc"`
+	Id      *big.Int            `json:"id"`
+	Method  string              `json:"method"`
+	Params  []UserOperationJSON `json:"params"` //1st User op, 2nd entry point
+}
+type Response struct {
+	Jsonrpc string   `json:"jsonrpc"`
+	Id      *big.Int `json:"id"`
+	Result  Result   `json:"Result"`
+}
+type Result struct {
+	Success bool
+	TxHash  common.Hash
+}
+
+func main() {
+	args, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if err := loadEnvironment(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading configuration:", err)
+		os.Exit(1)
+	}
+	if len(args) > 0 {
+		os.Exit(runCommand(args))
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+	if conf, err = loadConfig(); err != nil {
+		log.Crit("invalid configuration", "error", err)
+	}
+	if err := loadRevertABIs(conf.ABIDir); err != nil {
+		log.Crit("failed to load ABIs", "dir", conf.ABIDir, "error", err)
+	}
+	if conf.Metrics {
+		metrics.Enabled = true
+		exp.Exp(metrics.DefaultRegistry)
+	}
+	bundlers, err := startBundlers(http.DefaultServeMux)
+	if err != nil {
+		log.Crit("failed to start bundler", "error", err)
+	}
+	go saveReputationOnExit(reputations(bundlers))
+
+	log.Info("listening", "addr", conf.ListenAddr)
+	if err := http.ListenAndServe(conf.ListenAddr, nil); err != nil {
+		log.Error("http server failed", "error", err)
+	}
+
+}
+
+func (b *bundler) handle_eth_sendUserOperation(respw http.ResponseWriter, req *http.Request) {
+	respw.Header().Set("Content-Type", "application/json")
+	respw.WriteHeader(200)
+	//copying the params of the call to a type userOperationWithEntryPoint struct for ease in sanity checks
+	var r Request
+	err := json.NewDecoder(req.Body).Decode(&r)
+	if err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	UopwithEP := NewTypeUserOperation(r.Params[0])
+	// Checking for safe Entry Point
+	if !b.checkSafeEntryPoint(UopwithEP) {
+		http.Error(respw, "Entry point not safe,", e.JsonRpcInvalidParams)
+		return
+	}
+	if err := b.checkOpVersion(UopwithEP.EntryPoint, UopwithEP.UserOperation); err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInvalidParams)
+		return
+	}
+	//basic sanity checks
+	//1. Check the length of params
+	if len(r.Params) != 1 {
+		http.Error(respw, "invalid number of params for eth_sendUserOperation", e.JsonRpcInvalidParams)
+		return
+	}
+
+	//2. Either the sender is an existing contract, or the initCode is not empty (but not both)
+	client := b.nodes.RPC()
+	conn := b.nodes.Eth()
+	senderCheck, err := addressHasCode(conn, UopwithEP.UserOperation.Sender)
+	if err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInternalError) //error type not sure
+		return
+	}
+
+	if !senderCheck && len(UopwithEP.UserOperation.InitCode) == 0 {
+		http.Error(respw, "neither sender nor initcode available", e.JsonRpcInvalidParams)
+		return
+	}
+
+	if senderCheck && len(UopwithEP.UserOperation.InitCode) != 0 {
+		http.Error(respw, "cant take wallet as well as InitCode", e.JsonRpcInvalidParams)
+		return
+	}
+
+	//3. Verification gas is sufficiently low
+	if UopwithEP.UserOperation.VerificationGasLimit.Cmp(conf.MaxVerificationGas) > 0 {
+		http.Error(respw, "verification gas higher than max_verification_gas", e.JsonRpcInvalidParams)
+		return
+	}
+	if !b.pool.HasRoom(UopwithEP.EntryPoint, UopwithEP.UserOperation, b.settings.MempoolMaxOps) {
+		http.Error(respw, "mempool is full", e.JsonRpcInternalError)
+		return
+	}
+	//4.preVerification gas is sufficiently high
+	if err := checkPreVerificationGas(b.chain.version(UopwithEP.EntryPoint), UopwithEP.UserOperation); err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInvalidParams)
+		return
+	}
+
+	//5. Paymaster is either zero address or contract with non zero code, registered and staked (checked in 9), sufficient deposit and not blacklisted
+	entities, err := parseEntities(UopwithEP.UserOperation)
+	if err != nil {
+		http.Error(respw, err.Error(), e.JsonRpcInvalidParams)
+		return
+	}
+	paymaster := entities.Paymaster
+	if rpcErr := checkReputation(b.reputation, b.pool, entities); rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+	paymasterCheck, err := addressHasCode(conn, paymaster)
+	if err != nil {
+		http.Error(respw, "error while getting code from sender address", e.JsonRpcInternalError) //error type not confirmed
+		return
+	}
+	if !(paymasterCheck || paymaster == zeroAddress) {
+		http.Error(respw, "paymaster not contract or zero address", e.JsonRpcInvalidParams)
+		return
+	}
+
+	//6. maxFeePerGas and maxPriorityFeeGas are greater or equal than block's basefee
+	currBaseFee, err := b.heads.nextBaseFee()
+	if err != nil {
+		http.Error(respw, "failed to get block basefee", e.JsonRpcInternalError)
+		return
+	}
+	if !(UopwithEP.UserOperation.MaxFeePerGas.Cmp(currBaseFee) > 0) { //
+		http.Error(respw, "Max fee per gas too low ", e.JsonRpcInvalidParams)
+		return
+	}
+	minPriorityFee := b.fees.minPriorityFee()
+	if UopwithEP.UserOperation.MaxPriorityFeePerGas.Cmp(minPriorityFee) < 0 {
+		http.Error(respw, fmt.Sprintf("Priority fee per gas too low, the minimum is %v", minPriorityFee), e.JsonRpcInvalidParams)
+		return
+	}
+	gasLimit, err := b.heads.gasLimit()
+	if err != nil {
+		http.Error(respw, "failed to get block gas limit", e.JsonRpcInternalError)
+		return
+	}
+	if maxOpGas(UopwithEP.UserOperation).Cmp(new(big.Int).SetUint64(gasLimit)) > 0 {
+		http.Error(respw, "gas limits exceed the block gas limit", e.JsonRpcInvalidParams)
+		return
+	}
+
+	//TODO-7. Sender does not have another user op already in the pool. if that is the case the new tx should have +1 nonce
+
+	//8. simulateValidation succeeds, with a valid signature. Its trace is checked in 9
+	// an op with initCode must deploy the account at the sender
+	if rpcErr, err := checkSenderAddress(conn, UopwithEP.EntryPoint, UopwithEP.UserOperation); err != nil {
+		http.Error(respw, "failed to get sender address", e.JsonRpcInternalError)
+		return
+	} else if rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+	userOpHash, err := b.userOpHash(UopwithEP.EntryPoint, UopwithEP.UserOperation)
+	if err != nil {
+		http.Error(respw, "failed to get userOpHash", e.JsonRpcInternalError)
+		return
+	}
+	sim, trace, err := b.simCache.simulateAndTrace(client, UopwithEP.EntryPoint, UopwithEP.UserOperation, userOpHash)
+	var rejection *simulationRejection
+	if errors.As(err, &rejection) {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rejection.rpcError()))
+		return
+	}
+	if err != nil {
+		log.Error("failed to simulate validation", "sender", UopwithEP.UserOperation.Sender, "error", err)
+		http.Error(respw, "Sim validation failed", e.JsonRpcInternalError)
+		return
+	}
+	if rpcErr := checkSimulationResult(sim); rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+	// the aggregator is only known once the account's validation ran
+	entities.Aggregator = sim.Aggregator
+	if rpcErr := checkReputation(b.reputation, b.pool, validationEntities{Aggregator: entities.Aggregator}); rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+
+	//9. No entity uses a banned opcode or accesses forbidden storage during validation (ERC-7562)
+	if rpcErr := checkOpcodeRules(trace, entities); rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+	rpcErr, stakeReqs := checkStorageRules(trace, entities)
+	if rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+
+	//10. Paymasters, aggregators and factories that need it have the minimum stake and unstake delay
+	rpcErr, err = checkStakes(entities.stakeRequirements(b.pool, stakeReqs), b.depositInfo(UopwithEP.EntryPoint))
+	if err != nil {
+		http.Error(respw, "failed to get entity stake", e.JsonRpcInternalError)
+		return
+	}
+	if rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+
+	//11. The paymaster, or the sender without one, can pay for this op and its other ops in the mempool
+	rpcErr, err = checkPrefund(b.pool, UopwithEP.EntryPoint, UopwithEP.UserOperation, entities, b.payerFunds(UopwithEP.EntryPoint))
+	if err != nil {
+		http.Error(respw, "failed to get payer funds", e.JsonRpcInternalError)
+		return
+	}
+	if rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+
+	//12. The op stays valid for at least MinValidity
+	if rpcErr := checkValidityWindow(sim.Window, time.Now()); rpcErr != nil {
+		json.NewEncoder(respw).Encode(r.WriteRPCError(rpcErr))
+		return
+	}
+
+	b.reputation.Seen(entities.reputationEntities()...)
+	b.userOps.Add(userOpHash, &storedUserOp{Op: UopwithEP.UserOperation, EntryPoint: UopwithEP.EntryPoint, Entities: entities})
+	// the op is bundled from the mempool by the bundling loop
+	b.pool.Add(UopwithEP.EntryPoint, UopwithEP.UserOperation, sim.Window)
+	json.NewEncoder(respw).Encode(NewRPCResult(r.Id, userOpHash))
+
+}
+
+func NewTypeUserOperation(json UserOperationJSON) UserOperationWithEntryPoint {
+	return UserOperationWithEntryPoint{
+		UserOperation: _UserOperation{
+			Sender:               json.UserOperation.Sender,
+			Nonce:                json.UserOperation.Nonce,
+			InitCode:             json.UserOperation.InitCode,
+			CallData:             json.UserOperation.CallData,
+			CallGasLimit:         json.UserOperation.CallGasLimit,
+			VerificationGasLimit: json.UserOperation.VerificationGasLimit,
+			PreVerificationGas:   json.UserOperation.PreVerificationGas,
+			MaxFeePerGas:         json.UserOperation.MaxFeePerGas,
+			MaxPriorityFeePerGas: json.UserOperation.MaxPriorityFeePerGas,
+			PaymasterAndData:     json.UserOperation.PaymasterAndData,
+			Signature:            json.UserOperation.Signature,
+
+			PaymasterVerificationGasLimit: json.UserOperation.PaymasterVerificationGasLimit,
+			PaymasterPostOpGasLimit:       json.UserOperation.PaymasterPostOpGasLimit,
+		},
+		EntryPoint: json.EntryPoint,
+	}
+}
+
+func (b *bundler) handle_eth_supportedEntryPoints(respw http.ResponseWriter, req *http.Request) {
+	respw.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(respw, b.chain.EntryPoints[0].String())
+}
+
+func (b *bundler) checkSafeEntryPoint(s UserOperationWithEntryPoint) bool {
+	return b.chain.supportsEntryPoint(s.EntryPoint)
+}
+
+func getClient() string {
+	return os.Getenv("CLIENT")
+}
+
+func getPaymaster(uop _UserOperation) common.Address {
+	paymaster, _, _ := splitEntityField("paymasterAndData", uop.PaymasterAndData)
+	return paymaster
+}
+
+func addressHasCode(conn *ethclient.Client, addy common.Address) (bool, error) { //for wallet as well as paymaster
+	ctx := context.Background()
+	code, err := conn.CodeAt(ctx, addy, nil)
+	if err != nil {
+		return false, err
+	}
+	if code != nil {
+		return true, nil
+	} else {
+		return false, nil
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/joho/godotenv"
 	"io"
 	"net/http/httptest"
@@ -13,7 +14,8 @@ func TestSupportedEntryPoints(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/eth_supportedEntryPoints", nil)
 	respw := httptest.NewRecorder()
-	handle_eth_supportedEntryPoints(respw, req)
+	b := &bundler{chain: &chainProfile{EntryPoints: []common.Address{common.HexToAddress(os.Getenv("ENTRYPOINT_CONTRACT"))}}}
+	b.handle_eth_supportedEntryPoints(respw, req)
 	res := respw.Result()
 	defer res.Body.Close()
 
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+)
+
+// newTransactor opens the keystore file keyIn and returns the bundler's
+// signer for the chain conn is connected to.
+func newTransactor(conn *ethclient.Client, keyIn, passphrase string) (*bind.TransactOpts, error) {
+	chainID, err := conn.ChainID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.Open(keyIn)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return bind.NewTransactorWithChainID(r, passphrase, chainID)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// simCacheKey identifies an op. The userOpHash does not cover the signature,
+// which validation depends on too.
+type simCacheKey struct {
+	UserOpHash    common.Hash
+	SignatureHash common.Hash
+}
+
+// simCacheEntry is a successful simulation and the state it depended on.
+type simCacheEntry struct {
+	Block    uint64
+	Sim      *simulationResult
+	Trace    *validationTrace
+	Accounts map[common.Address]accountVersion
+}
+
+// accountVersion identifies the state of an account at a block.
+type accountVersion struct {
+	Balance     *hexutil.Big   `json:"balance"`
+	Nonce       hexutil.Uint64 `json:"nonce"`
+	CodeHash    common.Hash    `json:"codeHash"`
+	StorageHash common.Hash    `json:"storageHash"`
+}
+
+func (v accountVersion) equal(o accountVersion) bool {
+	if v.Nonce != o.Nonce || v.CodeHash != o.CodeHash || v.StorageHash != o.StorageHash {
+		return false
+	}
+	if v.Balance == nil || o.Balance == nil {
+		return v.Balance == o.Balance
+	}
+	return (*big.Int)(v.Balance).Cmp((*big.Int)(o.Balance)) == 0
+}
+
+// simulationCache is an LRU cache of simulations. An entry simulated at an
+// older block is reused only if none of the accounts touched during
+// validation changed since, as reported by eth_getProof. The current block is
+// the head cache's latest.
+type simulationCache struct {
+	entries  *lru.Cache
+	heads    *headCache
+	simulate func(*rpc.Client, common.Address, _UserOperation) (*simulationResult, *validationTrace, error)
+
+	hits        metrics.Counter
+	misses      metrics.Counter
+	revalidated metrics.Counter
+	invalidated metrics.Counter
+}
+
+// newSimulationCache returns a cache of size entries of the simulations run
+// with simulate on the chain followed by heads. It does not cache if size is
+// zero. Its metrics are registered under metricsPrefix.
+func newSimulationCache(size int, metricsPrefix string, heads *headCache, simulate func(*rpc.Client, common.Address, _UserOperation) (*simulationResult, *validationTrace, error)) (*simulationCache, error) {
+	var entries *lru.Cache
+	if size > 0 {
+		var err error
+		if entries, err = lru.New(size); err != nil {
+			return nil, err
+		}
+	}
+	return &simulationCache{
+		entries:     entries,
+		heads:       heads,
+		simulate:    simulate,
+		hits:        metrics.NewRegisteredCounter(metricsPrefix+"simcache/hit", nil),
+		misses:      metrics.NewRegisteredCounter(metricsPrefix+"simcache/miss", nil),
+		revalidated: metrics.NewRegisteredCounter(metricsPrefix+"simcache/revalidated", nil),
+		invalidated: metrics.NewRegisteredCounter(metricsPrefix+"simcache/invalidated", nil),
+	}, nil
+}
+
+// simulateAndTrace returns the cached simulation of op against the EntryPoint
+// ep if it is still valid, and simulates it otherwise. A disabled cache, and
+// simulations against the pending block, always simulate. The key includes
+// the userOpHash, which differs between EntryPoints.
+func (c *simulationCache) simulateAndTrace(client *rpc.Client, ep common.Address, op _UserOperation, userOpHash common.Hash) (*simulationResult, *validationTrace, error) {
+	if c.entries == nil || simulationBlock() == "pending" {
+		return c.simulate(client, ep, op)
+	}
+	ctx := context.Background()
+	key := simCacheKey{UserOpHash: userOpHash, SignatureHash: crypto.Keccak256Hash(op.Signature)}
+	block, err := c.headNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cached, ok := c.entries.Get(key); ok {
+		entry := cached.(*simCacheEntry)
+		if entry.Block == block {
+			c.hits.Inc(1)
+			return entry.Sim, entry.Trace, nil
+		}
+		accounts, err := accountVersions(ctx, client, entry.Accounts, block)
+		if err != nil {
+			return nil, nil, err
+		}
+		if unchanged(entry.Accounts, accounts) {
+			c.hits.Inc(1)
+			c.revalidated.Inc(1)
+			c.entries.Add(key, &simCacheEntry{Block: block, Sim: entry.Sim, Trace: entry.Trace, Accounts: accounts})
+			return entry.Sim, entry.Trace, nil
+		}
+		c.invalidated.Inc(1)
+		c.entries.Remove(key)
+	}
+	c.misses.Inc(1)
+
+	sim, trace, err := c.simulate(client, ep, op)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the simulation is only cached if it ran at block
+	after, err := c.headNumber()
+	if err != nil || after != block {
+		return sim, trace, nil
+	}
+	touched := map[common.Address]accountVersion{}
+	for _, addr := range append(opEntities(op).reputationEntities(), op.Sender, sim.Aggregator) {
+		touched[addr] = accountVersion{}
+	}
+	for _, f := range trace.Frames {
+		touched[f.Address] = accountVersion{}
+	}
+	delete(touched, zeroAddress)
+	accounts, err := accountVersions(ctx, client, touched, block)
+	if err == nil {
+		c.entries.Add(key, &simCacheEntry{Block: block, Sim: sim, Trace: trace, Accounts: accounts})
+	}
+	return sim, trace, nil
+}
+
+// headNumber returns the number of the latest head.
+func (c *simulationCache) headNumber() (uint64, error) {
+	head, err := c.heads.latest()
+	if err != nil {
+		return 0, err
+	}
+	return head.Number.Uint64(), nil
+}
+
+// accountVersions fetches the version of every account in accounts at block
+// in one batch.
+func accountVersions(ctx context.Context, client *rpc.Client, accounts map[common.Address]accountVersion, block uint64) (map[common.Address]accountVersion, error) {
+	addrs := make([]common.Address, 0, len(accounts))
+	batch := make([]rpc.BatchElem, 0, len(accounts))
+	versions := make([]accountVersion, len(accounts))
+	for addr := range accounts {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getProof",
+			Args:   []interface{}{addr, []string{}, hexutil.EncodeUint64(block)},
+			Result: &versions[len(addrs)],
+		})
+		addrs = append(addrs, addr)
+	}
+	if err := client.BatchCallContext(ctx, batch); err != nil {
+		return nil, err
+	}
+	res := make(map[common.Address]accountVersion, len(addrs))
+	for i, addr := range addrs {
+		if batch[i].Error != nil {
+			return nil, batch[i].Error
+		}
+		res[addr] = versions[i]
+	}
+	return res, nil
+}
+
+func unchanged(before, after map[common.Address]accountVersion) bool {
+	for addr, v := range before {
+		if !v.equal(after[addr]) {
+			return false
+		}
+	}
+	return true
+}
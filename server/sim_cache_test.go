@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeChain serves the account versions of eth_getProof.
+type fakeChain struct {
+	accounts map[common.Address]accountVersion
+}
+
+func (f *fakeChain) GetProof(addr common.Address, keys []string, block string) accountVersion {
+	v := f.accounts[addr]
+	if v.Balance == nil {
+		v.Balance = new(hexutil.Big)
+	}
+	return v
+}
+
+func TestSimulationCache(t *testing.T) {
+	sender := common.HexToAddress("0xa1")
+	other := common.HexToAddress("0xb2")
+	chain := &fakeChain{accounts: map[common.Address]accountVersion{}}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", chain); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	heads := newHeadCache(nil, chainProfiles["sepolia"], 0)
+	heads.add(testHeader(10, 0))
+
+	simulations := 0
+	cache, err := newSimulationCache(16, "test/", heads, func(*rpc.Client, common.Address, _UserOperation) (*simulationResult, *validationTrace, error) {
+		simulations++
+		return &simulationResult{}, &validationTrace{Frames: []traceFrame{{Address: sender}}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := _UserOperation{Sender: sender, Signature: []byte{1}}
+	hash := common.HexToHash("0x01")
+	simulate := func(want int) {
+		t.Helper()
+		if _, _, err := cache.simulateAndTrace(client, common.Address{}, op, hash); err != nil {
+			t.Fatal(err)
+		}
+		if simulations != want {
+			t.Fatalf("%d simulations, want %d", simulations, want)
+		}
+	}
+
+	simulate(1)
+	simulate(1) // same block
+	heads.add(testHeader(11, 0))
+	chain.accounts[other] = accountVersion{Nonce: 1}
+	simulate(1) // untouched account changed
+	heads.add(testHeader(12, 0))
+	chain.accounts[sender] = accountVersion{StorageHash: common.HexToHash("0x02")}
+	simulate(2) // sender storage changed
+	op.Signature = []byte{2}
+	simulate(3) // other signature
+}
@@ -1,39 +1,78 @@
-package main
-
-import (
-	"context"
-	"fmt"
-	"os"
-
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
-)
-
-func (s _UserOperation) simValidation() (bool, error) {
-	conn, err := ethclient.Dial(getClient())
-	if err != nil {
-		return false, err
-	}
-	EP, err := NewEntryPoint(common.HexToAddress(os.Getenv("ENTRYPOINT_CONTRACT")), conn)
-	if err != nil {
-		return false, err
-	}
-	chainID, _ := conn.ChainID(context.Background())
-	rdr := string(os.Getenv("KEY_IN"))
-	r, err := os.Open(rdr)
-	if err != nil {
-		return false, err
-	}
-	auth, err := bind.NewTransactorWithChainID(r, os.Getenv("PASSPHRASE"), chainID)
-	if err != nil {
-		return false, err
-	}
-	uop_array := buildUserOperationArray(s) //temporary resort
-	tx, err := EP.SimulateValidation(auth, uop_array[0], false)
-	if err != nil {
-		return false, err
-	}
-	fmt.Println("Sim validation tx", tx)
-	return true, nil
-}
+package main
+
+import (
+	"fmt"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// callSimulateValidation runs simulateValidation with eth_call against the
+// latest block, or the pending one if configured, with overrides applied. A
+// revert other than ValidationResult is returned as a *simulationRejection.
+// simulateValidation always reverts, so a plain return is an error.
+func callSimulateValidation(client *rpc.Client, ep common.Address, version entryPointVersion, op _UserOperation, overrides stateOverrides) (*simulationResult, error) {
+	data, err := packSimulateValidation(version, op)
+	if err != nil {
+		return nil, err
+	}
+	out, err := callContract(client, ethereum.CallMsg{
+		From: zeroAddress,
+		To:   &ep,
+		Gas:  simulationGasLimit,
+		Data: data,
+	}, overrides)
+	if err != nil {
+		revert, ok := revertData(err)
+		if !ok {
+			return nil, err
+		}
+		res, decodeErr := decodeSimulationResult(revert)
+		if decodeErr == errSimulationReverted {
+			return nil, newSimulationRejection(revert)
+		}
+		return res, decodeErr
+	}
+	return nil, fmt.Errorf("simulateValidation of %s returned %s instead of reverting", ep, hexutil.Bytes(out))
+}
+
+// simulationRejection is a simulateValidation revert that is not its result,
+// i.e. the op is invalid.
+type simulationRejection struct {
+	Revert *revertReason
+}
+
+func newSimulationRejection(data []byte) *simulationRejection {
+	return &simulationRejection{Revert: decodeRevert(data)}
+}
+
+func (r *simulationRejection) Error() string {
+	return "simulateValidation reverted: " + r.Revert.String()
+}
+
+func (r *simulationRejection) rpcError() *RPCError {
+	code := e.JsonRpcRejectedByEntryPointOrAccount
+	if r.Revert.Entity == entityPaymaster {
+		code = e.JsonRpcRejectedByPaymaster
+	}
+	return &RPCError{
+		Code:    code,
+		Message: r.Error(),
+		Data:    r.Revert.rpcData(),
+	}
+}
+
+// checkSimulationResult rejects ops whose signature failed validation.
+func checkSimulationResult(sim *simulationResult) *RPCError {
+	if !sim.SigFailed {
+		return nil
+	}
+	return &RPCError{
+		Code:    e.JsonRpcInvalidSignature,
+		Message: "invalid user operation signature",
+	}
+}
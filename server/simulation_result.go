@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// simulationResultABI holds the errors EntryPoint v0.6 reverts
+// simulateValidation with.
+const simulationResultABI = `[
+	{"type": "error", "name": "ValidationResult", "inputs": [
+		{"name": "returnInfo", "type": "tuple", "components": [
+			{"name": "preOpGas", "type": "uint256"},
+			{"name": "prefund", "type": "uint256"},
+			{"name": "sigFailed", "type": "bool"},
+			{"name": "validAfter", "type": "uint48"},
+			{"name": "validUntil", "type": "uint48"},
+			{"name": "paymasterContext", "type": "bytes"}
+		]},
+		{"name": "senderInfo", "type": "tuple", "components": [
+			{"name": "stake", "type": "uint256"},
+			{"name": "unstakeDelaySec", "type": "uint256"}
+		]},
+		{"name": "factoryInfo", "type": "tuple", "components": [
+			{"name": "stake", "type": "uint256"},
+			{"name": "unstakeDelaySec", "type": "uint256"}
+		]},
+		{"name": "paymasterInfo", "type": "tuple", "components": [
+			{"name": "stake", "type": "uint256"},
+			{"name": "unstakeDelaySec", "type": "uint256"}
+		]}
+	]},
+	{"type": "error", "name": "ValidationResultWithAggregation", "inputs": [
+		{"name": "returnInfo", "type": "tuple", "components": [
+			{"name": "preOpGas", "type": "uint256"},
+			{"name": "prefund", "type": "uint256"},
+			{"name": "sigFailed", "type": "bool"},
+			{"name": "validAfter", "type": "uint48"},
+			{"name": "validUntil", "type": "uint48"},
+			{"name": "paymasterContext", "type": "bytes"}
+		]},
+		{"name": "senderInfo", "type": "tuple", "components": [
+			{"name": "stake", "type": "uint256"},
+			{"name": "unstakeDelaySec", "type": "uint256"}
+		]},
+		{"name": "factoryInfo", "type": "tuple", "components": [
+			{"name": "stake", "type": "uint256"},
+			{"name": "unstakeDelaySec", "type": "uint256"}
+		]},
+		{"name": "paymasterInfo", "type": "tuple", "components": [
+			{"name": "stake", "type": "uint256"},
+			{"name": "unstakeDelaySec", "type": "uint256"}
+		]},
+		{"name": "aggregatorInfo", "type": "tuple", "components": [
+			{"name": "aggregator", "type": "address"},
+			{"name": "stakeInfo", "type": "tuple", "components": [
+				{"name": "stake", "type": "uint256"},
+				{"name": "unstakeDelaySec", "type": "uint256"}
+			]}
+		]}
+	]}
+]`
+
+var simulationResultErrors = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(simulationResultABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// validityWindow is when an op may be included. Zero means no bound.
+type validityWindow struct {
+	ValidAfter uint64
+	ValidUntil uint64
+}
+
+func (w validityWindow) notYetValid(now time.Time) bool {
+	return w.ValidAfter > uint64(now.Unix())
+}
+
+// expiresWithin reports whether the op is no longer valid at now + d.
+func (w validityWindow) expiresWithin(now time.Time, d time.Duration) bool {
+	return w.ValidUntil != 0 && w.ValidUntil < uint64(now.Add(d).Unix())
+}
+
+// checkValidityWindow rejects ops that expire within the configured
+// MinValidity of now.
+func checkValidityWindow(w validityWindow, now time.Time) *RPCError {
+	minValidity := 30 * time.Second
+	if conf != nil {
+		minValidity = conf.MinValidity
+	}
+	if !w.expiresWithin(now, minValidity) {
+		return nil
+	}
+	return &RPCError{
+		Code:    e.JsonRpcOutOfTimeRange,
+		Message: fmt.Sprintf("op expires at %d, less than %v from now", w.ValidUntil, minValidity),
+		Data: map[string]interface{}{
+			"validAfter": w.ValidAfter,
+			"validUntil": w.ValidUntil,
+		},
+	}
+}
+
+// stakeInfo is the stake of an entity as reported by the simulation.
+type stakeInfo struct {
+	Stake           *big.Int
+	UnstakeDelaySec *big.Int
+}
+
+// simulationResult is the outcome of simulateValidation. The window is the
+// intersection of the account's and the paymaster's.
+type simulationResult struct {
+	PreOpGas         *big.Int
+	Prefund          *big.Int
+	SigFailed        bool
+	Window           validityWindow
+	PaymasterContext []byte
+
+	SenderInfo    stakeInfo
+	FactoryInfo   stakeInfo
+	PaymasterInfo stakeInfo
+	// Aggregator is the zero address if the account uses none.
+	Aggregator     common.Address
+	AggregatorInfo stakeInfo
+}
+
+// decodeSimulationResult decodes the ValidationResult or
+// ValidationResultWithAggregation revert of simulateValidation. Other reverts
+// return errSimulationReverted.
+func decodeSimulationResult(out []byte) (*simulationResult, error) {
+	for _, name := range []string{"ValidationResult", "ValidationResultWithAggregation"} {
+		abiErr := simulationResultErrors.Errors[name]
+		if len(out) < 4 || !bytes.Equal(out[:4], abiErr.ID[:4]) {
+			continue
+		}
+		values, err := abiErr.Inputs.Unpack(out[4:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		var decoded struct {
+			ReturnInfo struct {
+				PreOpGas         *big.Int
+				Prefund          *big.Int
+				SigFailed        bool
+				ValidAfter       *big.Int
+				ValidUntil       *big.Int
+				PaymasterContext []byte
+			}
+			SenderInfo     stakeInfo
+			FactoryInfo    stakeInfo
+			PaymasterInfo  stakeInfo
+			AggregatorInfo struct {
+				Aggregator common.Address
+				StakeInfo  stakeInfo
+			}
+		}
+		if err := abiErr.Inputs.Copy(&decoded, values); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		info := decoded.ReturnInfo
+		return &simulationResult{
+			PreOpGas:         info.PreOpGas,
+			Prefund:          info.Prefund,
+			SigFailed:        info.SigFailed,
+			Window:           validityWindow{ValidAfter: info.ValidAfter.Uint64(), ValidUntil: info.ValidUntil.Uint64()},
+			PaymasterContext: info.PaymasterContext,
+			SenderInfo:       decoded.SenderInfo,
+			FactoryInfo:      decoded.FactoryInfo,
+			PaymasterInfo:    decoded.PaymasterInfo,
+			Aggregator:       decoded.AggregatorInfo.Aggregator,
+			AggregatorInfo:   decoded.AggregatorInfo.StakeInfo,
+		}, nil
+	}
+	return nil, errSimulationReverted
+}
+
+var errSimulationReverted = errors.New("simulateValidation reverted")
+
+// revertData returns the revert data carried by an eth_call error.
+func revertData(err error) ([]byte, bool) {
+	var de rpc.DataError
+	if !errors.As(err, &de) {
+		return nil, false
+	}
+	s, ok := de.ErrorData().(string)
+	if !ok {
+		return nil, false
+	}
+	data, err := hexutil.Decode(s)
+	return data, err == nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type testStakeInfo struct {
+	Stake           *big.Int
+	UnstakeDelaySec *big.Int
+}
+
+func packValidationResult(t *testing.T, validAfter, validUntil int64) []byte {
+	t.Helper()
+	abiErr := simulationResultErrors.Errors["ValidationResult"]
+	stake := testStakeInfo{Stake: big.NewInt(0), UnstakeDelaySec: big.NewInt(0)}
+	packed, err := abiErr.Inputs.Pack(struct {
+		PreOpGas         *big.Int
+		Prefund          *big.Int
+		SigFailed        bool
+		ValidAfter       *big.Int
+		ValidUntil       *big.Int
+		PaymasterContext []byte
+	}{big.NewInt(50000), big.NewInt(1e15), false, big.NewInt(validAfter), big.NewInt(validUntil), nil}, stake, stake, stake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(abiErr.ID[:4:4], packed...)
+}
+
+func TestDecodeSimulationResult(t *testing.T) {
+	res, err := decodeSimulationResult(packValidationResult(t, 100, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PreOpGas.Int64() != 50000 || res.Prefund.Int64() != 1e15 || res.Window != (validityWindow{100, 200}) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if _, err := decodeSimulationResult([]byte{0x08, 0xc3, 0x79, 0xa0}); err != errSimulationReverted {
+		t.Fatalf("other revert: got %v, want errSimulationReverted", err)
+	}
+}
+
+func TestSimulationRejection(t *testing.T) {
+	epABI, err := EntryPointMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failedOp := epABI.Errors["FailedOp"]
+	args, err := failedOp.Inputs.Pack(big.NewInt(0), "AA31 paymaster deposit too low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newSimulationRejection(append(failedOp.ID[:4:4], args...))
+	if r.Revert.Reason != "AA31 paymaster deposit too low" || r.Revert.Entity != entityPaymaster || r.Revert.OpIndex.Sign() != 0 {
+		t.Fatalf("unexpected rejection %+v", r)
+	}
+	if code := r.rpcError().Code; code != e.JsonRpcRejectedByPaymaster {
+		t.Fatalf("paymaster rejection code = %d, want %d", code, e.JsonRpcRejectedByPaymaster)
+	}
+
+	// Error(string)
+	reason := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"6e6f706500000000000000000000000000000000000000000000000000000000"
+	r = newSimulationRejection(common.FromHex(reason))
+	if r.Revert.Reason != "nope" || r.rpcError().Code != e.JsonRpcRejectedByEntryPointOrAccount {
+		t.Fatalf("unexpected rejection %+v", r)
+	}
+}
+
+func TestValidityWindow(t *testing.T) {
+	pool := &mempool{}
+
+	now := time.Unix(1000, 0)
+	if rpcErr := checkValidityWindow(validityWindow{ValidUntil: 1010}, now); rpcErr == nil {
+		t.Fatal("op expiring in 10s is accepted")
+	}
+	if rpcErr := checkValidityWindow(validityWindow{ValidUntil: 2000}, now); rpcErr != nil {
+		t.Fatalf("op valid for 1000s is rejected: %v", rpcErr)
+	}
+	if rpcErr := checkValidityWindow(validityWindow{}, now); rpcErr != nil {
+		t.Fatalf("op without expiry is rejected: %v", rpcErr)
+	}
+
+	op := func(nonce int64) _UserOperation {
+		return _UserOperation{Sender: common.HexToAddress("0xa1"), Nonce: big.NewInt(nonce)}
+	}
+	ep := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	pool.Add(ep, op(0), validityWindow{})
+	pool.Add(ep, op(1), validityWindow{ValidAfter: 1100})
+	pool.Add(ep, op(2), validityWindow{ValidUntil: 900})
+	if ready := pool.Drain(now); len(ready) != 1 || ready[0].Op.Nonce.Int64() != 0 {
+		t.Fatalf("drain at 1000: got %v, want only nonce 0", ready)
+	}
+	if ready := pool.Drain(time.Unix(1100, 0)); len(ready) != 1 || ready[0].Op.Nonce.Int64() != 1 {
+		t.Fatalf("drain at 1100: got %v, want only nonce 1", ready)
+	}
+	if pool.Len() != 0 {
+		t.Fatalf("expired op is still queued")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// EntryPoint v0.7 has no simulateValidation: reference bundlers run that of
+// EntryPointSimulations by overriding the EntryPoint's code in eth_call. The
+// bundler does not ship that contract, so simulateValidationV07 takes the same
+// steps from Go instead, calling the real EntryPoint, its SenderCreator, the
+// account and the paymaster in the in-process EVM.
+
+// v07ValidationABI holds the calls EntryPoint v0.7 makes to the account and
+// the paymaster to validate an op.
+const v07ValidationABI = `[
+	{"type": "function", "name": "validateUserOp", "inputs": [
+		{"name": "userOp", "type": "tuple", "components": [
+			{"name": "sender", "type": "address"},
+			{"name": "nonce", "type": "uint256"},
+			{"name": "initCode", "type": "bytes"},
+			{"name": "callData", "type": "bytes"},
+			{"name": "accountGasLimits", "type": "bytes32"},
+			{"name": "preVerificationGas", "type": "uint256"},
+			{"name": "gasFees", "type": "bytes32"},
+			{"name": "paymasterAndData", "type": "bytes"},
+			{"name": "signature", "type": "bytes"}
+		]},
+		{"name": "userOpHash", "type": "bytes32"},
+		{"name": "missingAccountFunds", "type": "uint256"}
+	], "outputs": [
+		{"name": "validationData", "type": "uint256"}
+	]},
+	{"type": "function", "name": "validatePaymasterUserOp", "inputs": [
+		{"name": "userOp", "type": "tuple", "components": [
+			{"name": "sender", "type": "address"},
+			{"name": "nonce", "type": "uint256"},
+			{"name": "initCode", "type": "bytes"},
+			{"name": "callData", "type": "bytes"},
+			{"name": "accountGasLimits", "type": "bytes32"},
+			{"name": "preVerificationGas", "type": "uint256"},
+			{"name": "gasFees", "type": "bytes32"},
+			{"name": "paymasterAndData", "type": "bytes"},
+			{"name": "signature", "type": "bytes"}
+		]},
+		{"name": "userOpHash", "type": "bytes32"},
+		{"name": "maxCost", "type": "uint256"}
+	], "outputs": [
+		{"name": "context", "type": "bytes"},
+		{"name": "validationData", "type": "uint256"}
+	]}
+]`
+
+var v07ValidationCalls = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(v07ValidationABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+const (
+	// v07ValidationOverhead is the gas EntryPoint v0.7 spends validating an
+	// op besides its calls to the factory, account and paymaster: copying and
+	// hashing the op, and updating the nonce and the deposit. It errs high, as
+	// PreOpGas sets the estimated verificationGasLimit.
+	v07ValidationOverhead = 40_000
+	// maxRevertDataLength is how much of an account's or paymaster's revert
+	// EntryPoint v0.7 keeps in FailedOpWithRevert.
+	maxRevertDataLength = 2048
+	// maxGasValueBits bounds the gas fields of v0.7 ops, which the EntryPoint
+	// adds up unchecked.
+	maxGasValueBits = 120
+)
+
+// v07Simulation is the state of simulateValidationV07.
+type v07Simulation struct {
+	evm    *vm.EVM
+	logger *validationLogger
+	ep     common.Address
+	// gasUsed is the gas used by the calls to the factory and the account,
+	// then also the paymaster.
+	gasUsed uint64
+}
+
+// simulateValidationV07 validates op against the EntryPoint v0.7 ep in evm, as
+// EntryPointSimulations.simulateValidation does, tracing with logger, the
+// tracer of evm. Rejections are *simulationRejection with the FailedOp or
+// FailedOpWithRevert the EntryPoint would revert with.
+func simulateValidationV07(evm *vm.EVM, logger *validationLogger, ep common.Address, op _UserOperation) (*simulationResult, error) {
+	s := &v07Simulation{evm: evm, logger: logger, ep: ep}
+	epCaller, err := NewEntryPointV07Caller(ep, s)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := userOpHashV07(op, ep, evm.ChainConfig().ChainID.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	packed := packUserOperationV07(op)
+	entities := opEntities(op)
+	rules := evm.ChainConfig().Rules(evm.Context.BlockNumber, evm.Context.Random != nil)
+	evm.StateDB.PrepareAccessList(ep, nil, vm.ActivePrecompiles(rules), nil)
+
+	if !entities.HasInitCode && evm.StateDB.GetCodeSize(op.Sender) == 0 {
+		return nil, failedOp("AA20 account not deployed")
+	}
+	if entities.Paymaster != zeroAddress && evm.StateDB.GetCodeSize(entities.Paymaster) == 0 {
+		return nil, failedOp("AA30 paymaster not deployed")
+	}
+	for _, gas := range []*big.Int{op.PreVerificationGas, op.VerificationGasLimit, op.CallGasLimit, op.PaymasterVerificationGasLimit,
+		op.PaymasterPostOpGasLimit, op.MaxFeePerGas, op.MaxPriorityFeePerGas} {
+		if gas != nil && gas.BitLen() > maxGasValueBits {
+			return nil, failedOp("AA94 gas values overflow")
+		}
+	}
+	prefund := requiredPrefund(op)
+	verificationGas := op.VerificationGasLimit.Uint64()
+
+	if entities.HasInitCode {
+		if evm.StateDB.GetCodeSize(op.Sender) != 0 {
+			return nil, failedOp("AA10 sender already constructed")
+		}
+		logger.enter(ep)
+		sender, gasUsed, err := createSender(evm, ep, op.InitCode, verificationGas)
+		if err != nil {
+			return nil, err
+		}
+		s.gasUsed += gasUsed
+		switch {
+		case sender == zeroAddress:
+			return nil, failedOp("AA13 initCode failed or OOG")
+		case sender != op.Sender:
+			return nil, failedOp("AA14 initCode must return sender")
+		case evm.StateDB.GetCodeSize(sender) == 0:
+			return nil, failedOp("AA15 initCode must create sender")
+		}
+	}
+
+	missingFunds := new(big.Int)
+	if entities.Paymaster == zeroAddress {
+		deposit, err := epCaller.BalanceOf(nil, op.Sender)
+		if err != nil {
+			return nil, err
+		}
+		if deposit.Cmp(prefund) < 0 {
+			missingFunds.Sub(prefund, deposit)
+		}
+	}
+	ret, err := s.call(op.Sender, verificationGas, "validateUserOp", packed, hash, missingFunds)
+	if err != nil {
+		return nil, failedOpWithRevert("AA23 reverted", ret)
+	}
+	validationData := new(big.Int)
+	if err := v07ValidationCalls.UnpackIntoInterface(&validationData, "validateUserOp", ret); err != nil {
+		return nil, &simulationRejection{Revert: &revertReason{
+			Reason: fmt.Sprintf("validateUserOp returned %s, not a uint256", hexutil.Bytes(ret)),
+			Entity: entityAccount,
+			Data:   ret,
+		}}
+	}
+	if entities.Paymaster == zeroAddress {
+		deposit, err := epCaller.BalanceOf(nil, op.Sender)
+		if err != nil {
+			return nil, err
+		}
+		if deposit.Cmp(prefund) < 0 {
+			return nil, failedOp("AA21 didn't pay prefund")
+		}
+	}
+	key := new(big.Int).Rsh(op.Nonce, 64)
+	nonce, err := epCaller.GetNonce(nil, op.Sender, key)
+	if err != nil {
+		return nil, err
+	}
+	if nonce.Cmp(op.Nonce) != 0 {
+		return nil, failedOp("AA25 invalid account nonce")
+	}
+	if s.gasUsed > verificationGas {
+		return nil, failedOp("AA26 over verificationGasLimit")
+	}
+
+	var paymasterContext []byte
+	paymasterValidationData := new(big.Int)
+	if entities.Paymaster != zeroAddress {
+		deposit, err := epCaller.BalanceOf(nil, entities.Paymaster)
+		if err != nil {
+			return nil, err
+		}
+		if deposit.Cmp(prefund) < 0 {
+			return nil, failedOp("AA31 paymaster deposit too low")
+		}
+		ret, err := s.call(entities.Paymaster, op.PaymasterVerificationGasLimit.Uint64(), "validatePaymasterUserOp", packed, hash, prefund)
+		if err != nil {
+			return nil, failedOpWithRevert("AA33 reverted", ret)
+		}
+		var out struct {
+			Context        []byte
+			ValidationData *big.Int
+		}
+		if err := v07ValidationCalls.UnpackIntoInterface(&out, "validatePaymasterUserOp", ret); err != nil {
+			return nil, &simulationRejection{Revert: &revertReason{
+				Reason: fmt.Sprintf("validatePaymasterUserOp returned %s, not (bytes, uint256)", hexutil.Bytes(ret)),
+				Entity: entityPaymaster,
+				Data:   ret,
+			}}
+		}
+		paymasterContext, paymasterValidationData = out.Context, out.ValidationData
+	}
+
+	aggregator, window := parseValidationData(validationData)
+	paymasterAggregator, paymasterWindow := parseValidationData(paymasterValidationData)
+	if paymasterWindow.ValidAfter > window.ValidAfter {
+		window.ValidAfter = paymasterWindow.ValidAfter
+	}
+	if paymasterWindow.ValidUntil < window.ValidUntil {
+		window.ValidUntil = paymasterWindow.ValidUntil
+	}
+	sim := &simulationResult{
+		PreOpGas:         new(big.Int).SetUint64(s.gasUsed + v07ValidationOverhead),
+		Prefund:          prefund,
+		SigFailed:        aggregator == sigFailedAggregator || paymasterAggregator != zeroAddress,
+		Window:           window,
+		PaymasterContext: paymasterContext,
+	}
+	sim.PreOpGas.Add(sim.PreOpGas, op.PreVerificationGas)
+	for _, info := range []struct {
+		addr common.Address
+		dst  *stakeInfo
+	}{
+		{op.Sender, &sim.SenderInfo},
+		{entities.Factory, &sim.FactoryInfo},
+		{entities.Paymaster, &sim.PaymasterInfo},
+	} {
+		if *info.dst, err = s.stakeInfo(epCaller, info.addr); err != nil {
+			return nil, err
+		}
+	}
+	if aggregator != zeroAddress && aggregator != sigFailedAggregator {
+		sim.Aggregator = aggregator
+		if sim.AggregatorInfo, err = s.stakeInfo(epCaller, aggregator); err != nil {
+			return nil, err
+		}
+	}
+	return sim, nil
+}
+
+// sigFailedAggregator is the aggregator of validationData whose signature
+// check failed.
+var sigFailedAggregator = common.BigToAddress(big.NewInt(1))
+
+// parseValidationData splits the validationData of an account or paymaster
+// into its aggregator and validity window, as the EntryPoint does. A zero
+// validUntil means no expiry.
+func parseValidationData(data *big.Int) (common.Address, validityWindow) {
+	const maxUint48 = 1<<48 - 1
+	window := validityWindow{
+		ValidUntil: new(big.Int).Rsh(data, 160).Uint64() & maxUint48,
+		ValidAfter: new(big.Int).Rsh(data, 208).Uint64() & maxUint48,
+	}
+	if window.ValidUntil == 0 {
+		window.ValidUntil = maxUint48
+	}
+	return common.BigToAddress(data), window
+}
+
+// call calls method of to from the EntryPoint with gas, in a frame of its own.
+func (s *v07Simulation) call(to common.Address, gas uint64, method string, args ...interface{}) ([]byte, error) {
+	data, err := v07ValidationCalls.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.enter(s.ep)
+	ret, left, err := s.evm.Call(vm.AccountRef(s.ep), to, data, gas, new(big.Int))
+	s.gasUsed += gas - left
+	if len(ret) > maxRevertDataLength {
+		ret = ret[:maxRevertDataLength]
+	}
+	return ret, err
+}
+
+func (s *v07Simulation) stakeInfo(epCaller *EntryPointV07Caller, addr common.Address) (stakeInfo, error) {
+	if addr == zeroAddress {
+		return stakeInfo{Stake: new(big.Int), UnstakeDelaySec: new(big.Int)}, nil
+	}
+	info, err := epCaller.GetDepositInfo(nil, addr)
+	if err != nil {
+		return stakeInfo{}, err
+	}
+	return stakeInfo{Stake: info.Stake, UnstakeDelaySec: new(big.Int).SetUint64(uint64(info.UnstakeDelaySec))}, nil
+}
+
+// CodeAt and CallContract make s a bind.ContractCaller for the EntryPoint's
+// views, called in evm like the validation calls.
+func (s *v07Simulation) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return s.evm.StateDB.GetCode(contract), nil
+}
+
+func (s *v07Simulation) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	s.logger.enter(s.ep)
+	ret, _, err := s.evm.Call(vm.AccountRef(s.ep), *call.To, call.Data, simulationGasLimit, new(big.Int))
+	return ret, err
+}
+
+var _ bind.ContractCaller = (*v07Simulation)(nil)
+
+// failedOp returns the rejection of an op by EntryPoint v0.7 with
+// FailedOp(0, reason).
+func failedOp(reason string) error {
+	return v07Rejection("FailedOp", big.NewInt(0), reason)
+}
+
+// failedOpWithRevert returns the rejection of an op by EntryPoint v0.7 with
+// FailedOpWithRevert(0, reason, inner).
+func failedOpWithRevert(reason string, inner []byte) error {
+	return v07Rejection("FailedOpWithRevert", big.NewInt(0), reason, inner)
+}
+
+func v07Rejection(name string, args ...interface{}) error {
+	epABI, err := EntryPointV07MetaData.GetAbi()
+	if err != nil {
+		return err
+	}
+	abiErr := epABI.Errors[name]
+	data, err := abiErr.Inputs.Pack(args...)
+	if err != nil {
+		return err
+	}
+	return newSimulationRejection(append(abiErr.ID[:4:4], data...))
+}
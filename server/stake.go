@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// sameUnstakedEntityMempoolCount is how many ops of an entity may be in the
+// mempool before the entity has to be staked.
+const sameUnstakedEntityMempoolCount = 10
+
+// stakeRequirement records why an entity has to be staked.
+type stakeRequirement struct {
+	Entity  string
+	Address common.Address
+	Reason  string
+}
+
+// stakeRequirements adds the entities that always need stake, or that exceed
+// the unstaked mempool limit, to the requirements found while checking the
+// storage rules. Only the first requirement per address is kept.
+func (v validationEntities) stakeRequirements(pool *mempool, storage []stakeRequirement) []stakeRequirement {
+	var reqs []stakeRequirement
+	if v.Paymaster != zeroAddress {
+		reqs = append(reqs, stakeRequirement{Entity: entityPaymaster, Address: v.Paymaster, Reason: "paymasters must be staked"})
+	}
+	if v.Aggregator != zeroAddress {
+		reqs = append(reqs, stakeRequirement{Entity: entityAggregator, Address: v.Aggregator, Reason: "aggregators must be staked"})
+	}
+	for _, entity := range []string{entityPaymaster, entityFactory, entityAggregator} {
+		addr := v.address(entity)
+		if addr != zeroAddress && pool.CountEntity(addr) >= sameUnstakedEntityMempoolCount {
+			reqs = append(reqs, stakeRequirement{
+				Entity:  entity,
+				Address: addr,
+				Reason:  fmt.Sprintf("%s has %d or more ops in the mempool", entity, sameUnstakedEntityMempoolCount),
+			})
+		}
+	}
+	reqs = append(reqs, storage...)
+
+	seen := map[common.Address]bool{}
+	unique := reqs[:0]
+	for _, req := range reqs {
+		if !seen[req.Address] {
+			seen[req.Address] = true
+			unique = append(unique, req)
+		}
+	}
+	return unique
+}
+
+// checkStakes rejects the op if any entity in reqs has less than the
+// configured minimum stake or unstake delay. An error is only returned when
+// getDepositInfo fails.
+func checkStakes(reqs []stakeRequirement, getDepositInfo func(common.Address) (IStakeManagerDepositInfo, error)) (*RPCError, error) {
+	for _, req := range reqs {
+		info, err := getDepositInfo(req.Address)
+		if err != nil {
+			return nil, err
+		}
+		stake := info.Stake
+		if stake == nil || !info.Staked {
+			stake = common.Big0
+		}
+		if stake.Cmp(conf.MinStake) >= 0 && uint64(info.UnstakeDelaySec) >= conf.MinUnstakeDelay {
+			continue
+		}
+		return &RPCError{
+			Code: e.JsonRpcInsufficientStake,
+			Message: fmt.Sprintf("%s %s has stake %v with unstake delay %ds, requires %v with %ds: %s",
+				req.Entity, req.Address, stake, info.UnstakeDelaySec, conf.MinStake, conf.MinUnstakeDelay, req.Reason),
+			Data: map[string]interface{}{
+				"entity":                 req.Entity,
+				"address":                req.Address,
+				"reason":                 req.Reason,
+				"stake":                  stake,
+				"minimumStake":           conf.MinStake,
+				"unstakeDelaySec":        info.UnstakeDelaySec,
+				"minimumUnstakeDelaySec": conf.MinUnstakeDelay,
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// depositInfo returns a lookup of the deposit and stake of an address in the
+// EntryPoint ep.
+func (b *bundler) depositInfo(ep common.Address) func(common.Address) (IStakeManagerDepositInfo, error) {
+	return func(addr common.Address) (IStakeManagerDepositInfo, error) {
+		EP, err := NewEntryPoint(ep, b.nodes.Eth())
+		if err != nil {
+			return IStakeManagerDepositInfo{}, err
+		}
+		return EP.GetDepositInfo(nil, addr)
+	}
+}
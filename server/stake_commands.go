@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	typ "github.com/ethereum/go-ethereum/core/types"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+)
+
+const commandUsage = `usage:
+  bundler [flags]                            run the bundler RPC server
+  bundler [flags] config check               validate the configuration
+  bundler stake add -delay <sec> -value <wei>
+  bundler stake unlock
+  bundler stake withdraw -to <address>
+  bundler deposit to -value <wei> [-account <address>]
+  bundler deposit withdraw -to <address> -amount <wei>
+  bundler deposit info [-account <address>]
+
+stake and deposit commands take -chain <name> to pick one of CHAINS, and
+-entrypoint <address> to pick one of its EntryPoints.
+`
+
+// stakeSession bundles everything a stake or deposit command needs to talk to
+// the EntryPoint with the configured signer.
+type stakeSession struct {
+	conn *ethclient.Client
+	ep   *EntryPoint
+	auth *bind.TransactOpts
+}
+
+// runCommand executes a CLI subcommand and returns the process exit code.
+func runCommand(args []string) int {
+	var err error
+	switch args[0] {
+	case "stake":
+		err = runStakeCommand(args[1:])
+	case "deposit":
+		err = runDepositCommand(args[1:])
+	case "config":
+		err = runConfigCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(commandUsage)
+		return 0
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 1
+	}
+	return 0
+}
+
+func runStakeCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing stake subcommand")
+	}
+	fs := flag.NewFlagSet("stake "+args[0], flag.ContinueOnError)
+	delay := fs.Uint("delay", 0, "unstake delay in seconds")
+	value := fs.String("value", "0", "amount of wei to stake")
+	to := fs.String("to", "", "address receiving the withdrawn stake")
+	chain, entryPoint := sessionFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	s, err := newStakeSession(*chain, *entryPoint)
+	if err != nil {
+		return err
+	}
+	account := s.auth.From
+	switch args[0] {
+	case "add":
+		amount, err := parseWei(*value)
+		if err != nil {
+			return err
+		}
+		if *delay == 0 {
+			return errors.New("-delay is required")
+		}
+		if *delay > math.MaxUint32 {
+			return fmt.Errorf("-delay must be at most %d seconds", uint64(math.MaxUint32))
+		}
+		return s.transact(account, func(auth *bind.TransactOpts) (*typ.Transaction, error) {
+			auth.Value = amount
+			return s.ep.AddStake(auth, uint32(*delay))
+		})
+	case "unlock":
+		return s.transact(account, s.ep.UnlockStake)
+	case "withdraw":
+		if !common.IsHexAddress(*to) {
+			return errors.New("-to must be a valid address")
+		}
+		return s.transact(account, func(auth *bind.TransactOpts) (*typ.Transaction, error) {
+			return s.ep.WithdrawStake(auth, common.HexToAddress(*to))
+		})
+	}
+	return fmt.Errorf("unknown stake subcommand %q", args[0])
+}
+
+func runDepositCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing deposit subcommand")
+	}
+	fs := flag.NewFlagSet("deposit "+args[0], flag.ContinueOnError)
+	value := fs.String("value", "0", "amount of wei to deposit")
+	amount := fs.String("amount", "0", "amount of wei to withdraw")
+	accountFlag := fs.String("account", "", "account to deposit for or inspect (default: signer)")
+	to := fs.String("to", "", "address receiving the withdrawn deposit")
+	chain, entryPoint := sessionFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	s, err := newStakeSession(*chain, *entryPoint)
+	if err != nil {
+		return err
+	}
+	account := s.auth.From
+	if *accountFlag != "" {
+		if !common.IsHexAddress(*accountFlag) {
+			return errors.New("-account must be a valid address")
+		}
+		account = common.HexToAddress(*accountFlag)
+	}
+	switch args[0] {
+	case "to":
+		wei, err := parseWei(*value)
+		if err != nil {
+			return err
+		}
+		return s.transact(account, func(auth *bind.TransactOpts) (*typ.Transaction, error) {
+			auth.Value = wei
+			return s.ep.DepositTo(auth, account)
+		})
+	case "withdraw":
+		wei, err := parseWei(*amount)
+		if err != nil {
+			return err
+		}
+		if !common.IsHexAddress(*to) {
+			return errors.New("-to must be a valid address")
+		}
+		return s.transact(s.auth.From, func(auth *bind.TransactOpts) (*typ.Transaction, error) {
+			return s.ep.WithdrawTo(auth, common.HexToAddress(*to), wei)
+		})
+	case "info":
+		return s.printDepositInfo(account)
+	}
+	return fmt.Errorf("unknown deposit subcommand %q", args[0])
+}
+
+// sessionFlags adds the -chain and -entrypoint flags to fs.
+func sessionFlags(fs *flag.FlagSet) (chain, entryPoint *string) {
+	chain = fs.String("chain", "", "chain in CHAINS to use (default: the only one)")
+	entryPoint = fs.String("entrypoint", "", "EntryPoint of the chain to use (default: the one bundles are sent to)")
+	return chain, entryPoint
+}
+
+// newStakeSession loads the configuration of the chain named chainName and
+// connects to its first node, with its signer, and to its EntryPoint
+// entryPoint, or the one bundles are sent to if empty.
+func newStakeSession(chainName, entryPoint string) (*stakeSession, error) {
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := c.chain(chainName)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ethclient.Dial(settings.NodeURLs[0])
+	if err != nil {
+		return nil, err
+	}
+	chain, err := loadChainProfile(conn, settings)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := chain.stakeEntryPoint(entryPoint)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := NewEntryPoint(addr, conn)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newTransactor(conn, settings.KeyIn, settings.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Chain", chain.Name, "EntryPoint", addr.Hex())
+	return &stakeSession{conn: conn, ep: ep, auth: auth}, nil
+}
+
+// chain returns the settings of the chain named name, which may be left out
+// when only one chain is configured.
+func (c *bundlerConfig) chain(name string) (*chainSettings, error) {
+	if name == "" && len(c.Chains) == 1 {
+		return c.Chains[0], nil
+	}
+	names := make([]string, len(c.Chains))
+	for i, settings := range c.Chains {
+		if settings.Name == name {
+			return settings, nil
+		}
+		names[i] = settings.Name
+	}
+	if name == "" {
+		return nil, fmt.Errorf("-chain is required, configured chains are %s", strings.Join(names, ", "))
+	}
+	return nil, fmt.Errorf("-chain: %q is not in CHAINS (%s)", name, strings.Join(names, ", "))
+}
+
+// stakeEntryPoint returns the EntryPoint raw names, which must be one of the
+// chain's, or the one bundles are sent to if raw is empty.
+func (c *chainProfile) stakeEntryPoint(raw string) (common.Address, error) {
+	if raw == "" {
+		return c.EntryPoints[0], nil
+	}
+	if !common.IsHexAddress(raw) {
+		return zeroAddress, fmt.Errorf("-entrypoint: invalid address %q", raw)
+	}
+	ep := common.HexToAddress(raw)
+	if !c.supportsEntryPoint(ep) {
+		return zeroAddress, fmt.Errorf("-entrypoint: %s is not an EntryPoint of %s, which has %v", ep, c.Name, c.EntryPoints)
+	}
+	return ep, nil
+}
+
+// transact prints the deposit info of account, sends the transaction built by
+// send, waits for it to be mined and prints the deposit info again.
+func (s *stakeSession) transact(account common.Address, send func(*bind.TransactOpts) (*typ.Transaction, error)) error {
+	fmt.Println("Before:")
+	if err := s.printDepositInfo(account); err != nil {
+		return err
+	}
+	tx, err := send(s.auth)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Sent transaction", tx.Hash().Hex())
+	receipt, err := bind.WaitMined(context.Background(), s.conn, tx)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != typ.ReceiptStatusSuccessful {
+		return fmt.Errorf("transaction %s reverted", tx.Hash().Hex())
+	}
+	fmt.Println("Mined in block", receipt.BlockNumber)
+	fmt.Println("After:")
+	return s.printDepositInfo(account)
+}
+
+func (s *stakeSession) printDepositInfo(account common.Address) error {
+	info, err := s.ep.GetDepositInfo(nil, account)
+	if err != nil {
+		return err
+	}
+	fmt.Println("  account:          ", account.Hex())
+	fmt.Println("  deposit:          ", info.Deposit)
+	fmt.Println("  staked:           ", info.Staked)
+	fmt.Println("  stake:            ", info.Stake)
+	fmt.Println("  unstake delay sec:", info.UnstakeDelaySec)
+	fmt.Println("  withdraw time:    ", info.WithdrawTime)
+	return nil
+}
+
+func parseWei(s string) (*big.Int, error) {
+	wei, ok := new(big.Int).SetString(s, 10)
+	if !ok || wei.Sign() < 0 {
+		return nil, fmt.Errorf("invalid wei amount %q", s)
+	}
+	return wei, nil
+}
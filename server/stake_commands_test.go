@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestStakeSessionChain(t *testing.T) {
+	single := &bundlerConfig{Chains: []*chainSettings{{Name: ""}}}
+	if settings, err := single.chain(""); err != nil || settings != single.Chains[0] {
+		t.Errorf("single chain: got %v, %v", settings, err)
+	}
+	multi := &bundlerConfig{Chains: []*chainSettings{{Name: "sepolia"}, {Name: "holesky"}}}
+	if _, err := multi.chain(""); err == nil {
+		t.Error("no -chain with two chains is accepted")
+	}
+	if settings, err := multi.chain("holesky"); err != nil || settings != multi.Chains[1] {
+		t.Errorf("-chain holesky: got %v, %v", settings, err)
+	}
+	if _, err := multi.chain("goerli"); err == nil {
+		t.Error("-chain of an unconfigured chain is accepted")
+	}
+}
+
+func TestStakeEntryPoint(t *testing.T) {
+	other := common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032")
+	chain := &chainProfile{Name: "sepolia", EntryPoints: []common.Address{entryPointV06, other}}
+	for _, tt := range []struct {
+		raw  string
+		want common.Address
+		ok   bool
+	}{
+		{"", entryPointV06, true},
+		{other.Hex(), other, true},
+		{"0x00000000000000000000000000000000000000aa", zeroAddress, false},
+		{"0x1234", zeroAddress, false},
+	} {
+		ep, err := chain.stakeEntryPoint(tt.raw)
+		if (err == nil) != tt.ok || ep != tt.want {
+			t.Errorf("-entrypoint %q: got %s, %v", tt.raw, ep, err)
+		}
+	}
+}
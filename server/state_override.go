@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// stateOverride replaces parts of an account for the duration of an eth_call,
+// in the format geth accepts. Unset fields are left as they are on chain.
+type stateOverride struct {
+	Balance   *hexutil.Big                `json:"balance,omitempty"`
+	Nonce     *hexutil.Uint64             `json:"nonce,omitempty"`
+	Code      *hexutil.Bytes              `json:"code,omitempty"`
+	State     map[common.Hash]common.Hash `json:"state,omitempty"`
+	StateDiff map[common.Hash]common.Hash `json:"stateDiff,omitempty"`
+}
+
+type stateOverrides map[common.Address]stateOverride
+
+// simulationBlock is the block simulations run against.
+func simulationBlock() string {
+	if conf != nil && conf.SimulatePending {
+		return "pending"
+	}
+	return "latest"
+}
+
+// callContract runs msg with eth_call against the simulation block, with
+// overrides applied if there are any.
+func callContract(client *rpc.Client, msg ethereum.CallMsg, overrides stateOverrides) ([]byte, error) {
+	args := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+		"data": hexutil.Bytes(msg.Data),
+	}
+	if msg.Gas != 0 {
+		args["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	params := []interface{}{args, simulationBlock()}
+	if len(overrides) > 0 {
+		params = append(params, overrides)
+	}
+	var out hexutil.Bytes
+	err := client.CallContext(context.Background(), &out, "eth_call", params...)
+	return out, err
+}
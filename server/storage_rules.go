@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// maxAssociatedOffset is how far past keccak(address || x) a slot may be and
+// still be associated with address, to cover mapping(address => struct).
+const maxAssociatedOffset = 128
+
+// associatedSlots returns the base slots keccak(addr || x) of every mapping
+// entry keyed by addr that was hashed during validation.
+func (t *validationTrace) associatedSlots(addr common.Address) []*big.Int {
+	key := common.BytesToHash(addr.Bytes())
+	var bases []*big.Int
+	for _, preimage := range t.Keccak {
+		if len(preimage) >= common.HashLength && bytes.Equal(preimage[:common.HashLength], key[:]) {
+			bases = append(bases, new(big.Int).SetBytes(crypto.Keccak256(preimage)))
+		}
+	}
+	return bases
+}
+
+func isAssociated(slot common.Hash, bases []*big.Int) bool {
+	s := slot.Big()
+	for _, base := range bases {
+		offset := new(big.Int).Sub(s, base)
+		if offset.Sign() >= 0 && offset.Cmp(big.NewInt(maxAssociatedOffset)) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (v validationEntities) address(entity string) common.Address {
+	switch entity {
+	case entityAccount:
+		return v.Sender
+	case entityPaymaster:
+		return v.Paymaster
+	case entityFactory:
+		return v.Factory
+	case entityAggregator:
+		return v.Aggregator
+	}
+	return zeroAddress
+}
+
+// checkStorageRules enforces the ERC-7562 storage rules on t:
+//   - any entity may access the sender's storage and slots associated with
+//     the sender, the latter only if the sender exists or the factory is staked
+//   - the account may access nothing else
+//   - paymasters and factories may also access their own storage and slots
+//     associated with them, and read any other storage, if they are staked
+//
+// Accesses that are only allowed for staked entities are returned as stake
+// requirements, to be checked with checkStakes.
+func checkStorageRules(t *validationTrace, entities validationEntities) (*RPCError, []stakeRequirement) {
+	var reqs []stakeRequirement
+	senderSlots := t.associatedSlots(entities.Sender)
+
+	for i, f := range t.Frames {
+		entity := t.entityOf(i, entities)
+		if entity == "" || f.Address == entities.Sender {
+			continue
+		}
+		entityAddr := entities.address(entity)
+		var entitySlots []*big.Int
+		if entity != entityAccount {
+			entitySlots = t.associatedSlots(entityAddr)
+		}
+		for _, access := range sortedAccesses(f) {
+			if isAssociated(access.slot, senderSlots) {
+				if entities.HasInitCode {
+					reqs = append(reqs, stakeRequirement{
+						Entity:  entityFactory,
+						Address: entities.Factory,
+						Reason:  storageAccessReason(entity, f.Address, access, "storage associated with an undeployed sender"),
+					})
+				}
+				continue
+			}
+			if entity == entityAccount {
+				return storageViolation(entity, f.Address, access, "the slot is not associated with the sender"), nil
+			}
+			if f.Address != entityAddr && !isAssociated(access.slot, entitySlots) && access.write {
+				return storageViolation(entity, f.Address, access, "only reads are allowed outside the entity's own storage"), nil
+			}
+			reqs = append(reqs, stakeRequirement{
+				Entity:  entity,
+				Address: entityAddr,
+				Reason:  storageAccessReason(entity, f.Address, access, "storage outside the sender"),
+			})
+		}
+	}
+	return nil, reqs
+}
+
+type storageAccess struct {
+	slot  common.Hash
+	write bool
+}
+
+func sortedAccesses(f traceFrame) []storageAccess {
+	accesses := make([]storageAccess, 0, len(f.Reads)+len(f.Writes))
+	for slot := range f.Reads {
+		accesses = append(accesses, storageAccess{slot: slot})
+	}
+	for slot := range f.Writes {
+		accesses = append(accesses, storageAccess{slot: slot, write: true})
+	}
+	sort.Slice(accesses, func(i, j int) bool {
+		if c := bytes.Compare(accesses[i].slot[:], accesses[j].slot[:]); c != 0 {
+			return c < 0
+		}
+		return !accesses[i].write && accesses[j].write
+	})
+	return accesses
+}
+
+func storageViolation(entity string, contract common.Address, access storageAccess, reason string) *RPCError {
+	return &RPCError{
+		Code:    e.JsonRpcBannedStorageAccess,
+		Message: fmt.Sprintf("%s has forbidden %s access to slot %s of %s: %s", entity, access.kind(), access.slot, contract, reason),
+		Data: map[string]interface{}{
+			"entity":   entity,
+			"contract": contract,
+			"slot":     access.slot,
+			"access":   access.kind(),
+		},
+	}
+}
+
+func storageAccessReason(entity string, contract common.Address, access storageAccess, what string) string {
+	return fmt.Sprintf("%s %ss slot %s of %s, %s", entity, access.kind(), access.slot, contract, what)
+}
+
+func (a storageAccess) kind() string {
+	if a.write {
+		return "write"
+	}
+	return "read"
+}
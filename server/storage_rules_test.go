@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// callCode returns code that calls target with all remaining gas.
+func callCode(target byte) []byte {
+	return []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), target, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP),
+	}
+}
+
+func TestStorageRules(t *testing.T) {
+	sender := common.HexToAddress("0xaa")
+	paymaster := common.HexToAddress("0xbb")
+	// token reads balances[sender] (mapping at slot 0), then runs extra
+	tokenCode := func(extra ...byte) []byte {
+		code := []byte{byte(vm.PUSH1), 0xaa, byte(vm.PUSH1), 0, byte(vm.MSTORE)}
+		code = append(code, byte(vm.PUSH1), 0, byte(vm.PUSH1), 32, byte(vm.MSTORE))
+		code = append(code, byte(vm.PUSH1), 64, byte(vm.PUSH1), 0, byte(vm.KECCAK256), byte(vm.SLOAD), byte(vm.POP))
+		code = append(code, extra...)
+		return append(code, byte(vm.STOP))
+	}
+	readSlot1 := []byte{byte(vm.PUSH1), 1, byte(vm.SLOAD), byte(vm.POP)}
+	writeSlot1 := []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 1, byte(vm.SSTORE)}
+	entities := validationEntities{Sender: sender, Paymaster: paymaster}
+
+	for _, tt := range []struct {
+		name         string
+		extra        []byte
+		violation    bool
+		requireStake bool
+	}{
+		{"associated slot", nil, false, false},
+		{"external read", readSlot1, false, true},
+		{"external write", writeSlot1, true, false},
+	} {
+		sdb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		sdb.SetCode(paymaster, callCode(0xcc))
+		sdb.SetCode(common.HexToAddress("0xcc"), tokenCode(tt.extra...))
+		trace := runValidationTracer(t, sdb, callCode(0xbb))
+
+		rpcErr, reqs := checkStorageRules(trace, entities)
+		if (rpcErr != nil) != tt.violation {
+			t.Errorf("%s: unexpected result %v", tt.name, rpcErr)
+		}
+		if rpcErr != nil && rpcErr.Data.(map[string]interface{})["slot"] != common.BigToHash(common.Big1) {
+			t.Errorf("%s: unexpected slot in %v", tt.name, rpcErr.Data)
+		}
+		if (len(reqs) > 0) != tt.requireStake {
+			t.Errorf("%s: unexpected stake requirements %v", tt.name, reqs)
+		}
+		if len(reqs) > 0 && (reqs[0].Address != paymaster || reqs[0].Entity != entityPaymaster) {
+			t.Errorf("%s: unexpected stake requirement %v", tt.name, reqs[0])
+		}
+	}
+}
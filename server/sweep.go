@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// sweeper moves the balance of an account we hold the key of to another
+// address, one transfer at a time.
+type sweeper struct {
+	conn *ethclient.Client
+	key  *bind.TransactOpts
+	// nonces sends the key's transactions, which may also be bundles.
+	nonces *accountNonces
+	to     common.Address
+	kind   string // accounting entry kind
+	// accountingLog is the file sweeps are recorded in.
+	accountingLog string
+
+	pending *pendingSweep
+}
+
+// pendingSweepTimeout is how long a sweep may stay unmined before it is taken
+// as dropped and the balance is swept again.
+const pendingSweepTimeout = 30 * time.Minute
+
+type pendingSweep struct {
+	hash  common.Hash
+	nonce uint64
+	sent  time.Time
+}
+
+// sweep transfers everything above keep, minus the transfer cost, to s.to. It
+// does nothing while the previous sweep is not mined yet.
+func (s *sweeper) sweep(keep *big.Int) {
+	ctx := context.Background()
+	if !s.settled(ctx) {
+		return
+	}
+	from := s.key.From
+	balance, err := s.conn.BalanceAt(ctx, from, nil)
+	if err != nil {
+		log.Error("failed to get balance to sweep", "account", from, "error", err)
+		return
+	}
+	gasPrice, err := s.conn.SuggestGasPrice(ctx)
+	if err != nil {
+		log.Error("failed to get gas price", "error", err)
+		return
+	}
+	value := new(big.Int).Sub(balance, keep)
+	value.Sub(value, new(big.Int).Mul(gasPrice, big.NewInt(int64(params.TxGas))))
+	if value.Sign() <= 0 {
+		return
+	}
+	tx, err := s.nonces.send(func(nonce uint64) (*types.Transaction, error) {
+		tx, err := s.key.Signer(from, types.NewTransaction(nonce, s.to, value, params.TxGas, gasPrice, nil))
+		if err != nil {
+			return nil, err
+		}
+		return tx, s.conn.SendTransaction(ctx, tx)
+	})
+	if err != nil {
+		log.Error("failed to send sweep transaction", "account", from, "error", err)
+		return
+	}
+	hash := tx.Hash()
+	s.pending = &pendingSweep{hash: hash, nonce: tx.Nonce(), sent: time.Now()}
+	log.Info("swept balance", "kind", s.kind, "from", from, "to", s.to, "value", value, "tx", hash)
+	recordAccounting(s.accountingLog, accountingEntry{
+		Kind:   s.kind,
+		From:   from,
+		To:     s.to,
+		Amount: value,
+		TxHash: hash,
+	})
+}
+
+// settled tells if the previous sweep is done with. It is once it is mined,
+// once another transaction took its nonce, or once it is unmined for
+// pendingSweepTimeout, in which case the node most likely dropped it and the
+// nonce is fetched again.
+func (s *sweeper) settled(ctx context.Context) bool {
+	if s.pending == nil {
+		return true
+	}
+	if _, err := s.conn.TransactionReceipt(ctx, s.pending.hash); err == nil {
+		s.pending = nil
+		return true
+	}
+	if nonce, err := s.conn.NonceAt(ctx, s.key.From, nil); err == nil && nonce > s.pending.nonce {
+		log.Warn("sweep transaction replaced", "kind", s.kind, "tx", s.pending.hash, "nonce", s.pending.nonce)
+		s.pending = nil
+		return true
+	}
+	if time.Since(s.pending.sent) < pendingSweepTimeout {
+		return false
+	}
+	log.Warn("sweep transaction not mined, sweeping again", "kind", s.kind, "tx", s.pending.hash, "sent", s.pending.sent)
+	s.nonces.reset()
+	s.pending = nil
+	return true
+}
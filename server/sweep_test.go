@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeSweepNode never finds a receipt, as for a dropped transaction.
+type fakeSweepNode struct {
+	*fakeNonce
+}
+
+func (f fakeSweepNode) GetTransactionReceipt(hash common.Hash) *struct{} {
+	return nil
+}
+
+func TestSweepDropped(t *testing.T) {
+	node := fakeSweepNode{&fakeNonce{count: 3}}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", node); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+	conn := ethclient.NewClient(client)
+	from := common.Address{1}
+	s := &sweeper{conn: conn, key: &bind.TransactOpts{From: from}, nonces: newAccountNonces(conn, from)}
+	s.nonces.next, s.nonces.known = 4, true
+	ctx := context.Background()
+
+	s.pending = &pendingSweep{hash: common.Hash{1}, nonce: 3, sent: time.Now()}
+	if s.settled(ctx) {
+		t.Fatal("sweep settled while pending")
+	}
+	s.pending.sent = time.Now().Add(-pendingSweepTimeout)
+	if !s.settled(ctx) || s.pending != nil {
+		t.Fatal("dropped sweep still pending after the timeout")
+	}
+	if s.nonces.known {
+		t.Error("nonce not fetched again after a dropped sweep")
+	}
+
+	s.pending = &pendingSweep{hash: common.Hash{2}, nonce: 2, sent: time.Now()}
+	if !s.settled(ctx) || s.pending != nil {
+		t.Error("sweep still pending after its nonce was taken")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethclient "github.com/ethereum/go-ethereum/ethclient"
+)
+
+// maxStoredUserOps bounds how many accepted ops are kept for
+// eth_getUserOperationByHash. The oldest ones are forgotten first.
+const maxStoredUserOps = 10000
+
+// storedUserOp is an op accepted by this bundler.
+type storedUserOp struct {
+	Op         _UserOperation
+	EntryPoint common.Address
+	Entities   validationEntities
+	TxHash     common.Hash // zero until the op is submitted
+}
+
+// userOpStore remembers the ops accepted by this bundler by userOpHash. It
+// does not survive restarts.
+type userOpStore struct {
+	mu    sync.Mutex
+	ops   map[common.Hash]*storedUserOp
+	order []common.Hash
+}
+
+func newUserOpStore() *userOpStore {
+	return &userOpStore{ops: map[common.Hash]*storedUserOp{}}
+}
+
+func (s *userOpStore) Add(hash common.Hash, op *storedUserOp) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ops[hash]; !ok {
+		s.order = append(s.order, hash)
+	}
+	s.ops[hash] = op
+	for len(s.order) > maxStoredUserOps {
+		delete(s.ops, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *userOpStore) Get(hash common.Hash) (storedUserOp, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.ops[hash]
+	if !ok {
+		return storedUserOp{}, false
+	}
+	return *op, true
+}
+
+// Submitted records the transaction the given ops for the EntryPoint ep were
+// bundled in. An op with the same sender and nonce for another EntryPoint is a
+// different op.
+func (s *userOpStore) Submitted(ep common.Address, ops []_UserOperation, txHash common.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stored := range s.ops {
+		if stored.EntryPoint != ep {
+			continue
+		}
+		for _, op := range ops {
+			if sameSenderAndNonce(stored.Op, op) {
+				stored.TxHash = txHash
+			}
+		}
+	}
+}
+
+// getUserOpHash returns the hash the EntryPoint identifies op by.
+func getUserOpHash(conn *ethclient.Client, ep common.Address, op _UserOperation) (common.Hash, error) {
+	EP, err := NewEntryPoint(ep, conn)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return EP.GetUserOpHash(nil, buildUserOperationArray(op)[0])
+}
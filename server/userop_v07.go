@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// entryPointVersion is the release of an EntryPoint, which decides the op
+// format, the userOpHash and the bindings used.
+type entryPointVersion string
+
+const (
+	// entryPointVersion06 takes a UserOperation, bound in EntryPoint.go.
+	entryPointVersion06 entryPointVersion = "0.6"
+	// entryPointVersion07 takes a PackedUserOperation, bound in EntryPointV07.go.
+	entryPointVersion07 entryPointVersion = "0.7"
+)
+
+// errV07Simulation is returned for simulateValidation calls to v0.7
+// EntryPoints, which have none. Their ops are validated in process with
+// simulateValidationV07.
+var errV07Simulation = errors.New("EntryPoint v0.7 has no simulateValidation")
+
+func parseEntryPointVersion(s string) (entryPointVersion, error) {
+	switch v := entryPointVersion(s); v {
+	case entryPointVersion06, entryPointVersion07:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown EntryPoint version %q, must be %s or %s", s, entryPointVersion06, entryPointVersion07)
+}
+
+// UserOperationV07JSON is an op in the unpacked RPC format of EntryPoint v0.7.
+// In _UserOperation, Factory and FactoryData make up InitCode, and Paymaster
+// and PaymasterData make up PaymasterAndData, without the paymaster gas
+// limits that v0.7 packs in between.
+type UserOperationV07JSON struct {
+	Sender                        common.Address  `json:"sender"`
+	Nonce                         *big.Int        `json:"nonce"`
+	Factory                       *common.Address `json:"factory,omitempty"`
+	FactoryData                   hexutil.Bytes   `json:"factoryData,omitempty"`
+	CallData                      hexutil.Bytes   `json:"callData"`
+	CallGasLimit                  *big.Int        `json:"callGasLimit"`
+	VerificationGasLimit          *big.Int        `json:"verificationGasLimit"`
+	PreVerificationGas            *big.Int        `json:"preVerificationGas"`
+	MaxFeePerGas                  *big.Int        `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas          *big.Int        `json:"maxPriorityFeePerGas"`
+	Paymaster                     *common.Address `json:"paymaster,omitempty"`
+	PaymasterVerificationGasLimit *big.Int        `json:"paymasterVerificationGasLimit,omitempty"`
+	PaymasterPostOpGasLimit       *big.Int        `json:"paymasterPostOpGasLimit,omitempty"`
+	PaymasterData                 hexutil.Bytes   `json:"paymasterData,omitempty"`
+	Signature                     hexutil.Bytes   `json:"signature"`
+}
+
+// UnmarshalJSON accepts ops in the format of either version. The version of
+// the EntryPoint is checked with checkOpFields.
+func (op *_UserOperation) UnmarshalJSON(data []byte) error {
+	type userOperation _UserOperation
+	var v struct {
+		userOperation
+		Factory       *common.Address `json:"factory"`
+		FactoryData   hexutil.Bytes   `json:"factoryData"`
+		Paymaster     *common.Address `json:"paymaster"`
+		PaymasterData hexutil.Bytes   `json:"paymasterData"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*op = _UserOperation(v.userOperation)
+	if v.Factory != nil || len(v.FactoryData) > 0 {
+		if len(op.InitCode) > 0 {
+			return errors.New("op has both initCode and factory")
+		}
+		if v.Factory != nil && *v.Factory != zeroAddress {
+			op.InitCode = append(v.Factory.Bytes(), v.FactoryData...)
+		}
+	}
+	if v.Paymaster != nil || len(v.PaymasterData) > 0 {
+		if len(op.PaymasterAndData) > 0 {
+			return errors.New("op has both paymasterAndData and paymaster")
+		}
+		if v.Paymaster != nil && *v.Paymaster != zeroAddress {
+			op.PaymasterAndData = append(v.Paymaster.Bytes(), v.PaymasterData...)
+			if op.PaymasterVerificationGasLimit == nil {
+				op.PaymasterVerificationGasLimit = new(big.Int)
+			}
+			if op.PaymasterPostOpGasLimit == nil {
+				op.PaymasterPostOpGasLimit = new(big.Int)
+			}
+		}
+	}
+	return nil
+}
+
+// checkOpFields rejects ops that do not fit the EntryPoint version: paymaster
+// gas limits are v0.7 only, and v0.7 requires them with a paymaster.
+func checkOpFields(version entryPointVersion, op _UserOperation) error {
+	v07Fields := op.PaymasterVerificationGasLimit != nil || op.PaymasterPostOpGasLimit != nil
+	switch version {
+	case entryPointVersion06:
+		if v07Fields {
+			return errors.New("paymasterVerificationGasLimit and paymasterPostOpGasLimit are not supported by EntryPoint v0.6")
+		}
+	case entryPointVersion07:
+		if getPaymaster(op) != zeroAddress && !v07Fields {
+			return errors.New("EntryPoint v0.7 ops take paymaster, paymasterData and the paymaster gas limits instead of paymasterAndData")
+		}
+		// they are packed in pairs into a word
+		for _, f := range []struct {
+			name  string
+			value *big.Int
+		}{
+			{"verificationGasLimit", op.VerificationGasLimit},
+			{"callGasLimit", op.CallGasLimit},
+			{"maxPriorityFeePerGas", op.MaxPriorityFeePerGas},
+			{"maxFeePerGas", op.MaxFeePerGas},
+			{"paymasterVerificationGasLimit", op.PaymasterVerificationGasLimit},
+			{"paymasterPostOpGasLimit", op.PaymasterPostOpGasLimit},
+		} {
+			if f.value != nil && (f.value.Sign() < 0 || f.value.BitLen() > 128) {
+				return fmt.Errorf("%s does not fit in 128 bits", f.name)
+			}
+		}
+	}
+	return nil
+}
+
+// toV07JSON returns op in the unpacked RPC format of EntryPoint v0.7.
+func (op _UserOperation) toV07JSON() UserOperationV07JSON {
+	v := UserOperationV07JSON{
+		Sender:                        op.Sender,
+		Nonce:                         op.Nonce,
+		CallData:                      op.CallData,
+		CallGasLimit:                  op.CallGasLimit,
+		VerificationGasLimit:          op.VerificationGasLimit,
+		PreVerificationGas:            op.PreVerificationGas,
+		MaxFeePerGas:                  op.MaxFeePerGas,
+		MaxPriorityFeePerGas:          op.MaxPriorityFeePerGas,
+		PaymasterVerificationGasLimit: op.PaymasterVerificationGasLimit,
+		PaymasterPostOpGasLimit:       op.PaymasterPostOpGasLimit,
+		Signature:                     op.Signature,
+	}
+	if factory, data, err := splitEntityField("initCode", op.InitCode); err == nil && factory != zeroAddress {
+		v.Factory, v.FactoryData = &factory, data
+	}
+	if paymaster, data, err := splitEntityField("paymasterAndData", op.PaymasterAndData); err == nil && paymaster != zeroAddress {
+		v.Paymaster, v.PaymasterData = &paymaster, data
+	}
+	return v
+}
+
+// packUserOperationV07 packs op into the PackedUserOperation handleOps of
+// EntryPoint v0.7 takes.
+func packUserOperationV07(op _UserOperation) PackedUserOperation {
+	packed := PackedUserOperation{
+		Sender:             op.Sender,
+		Nonce:              op.Nonce,
+		InitCode:           op.InitCode,
+		CallData:           op.CallData,
+		AccountGasLimits:   packUint128s(op.VerificationGasLimit, op.CallGasLimit),
+		PreVerificationGas: op.PreVerificationGas,
+		GasFees:            packUint128s(op.MaxPriorityFeePerGas, op.MaxFeePerGas),
+		Signature:          op.Signature,
+	}
+	if paymaster, data, err := splitEntityField("paymasterAndData", op.PaymasterAndData); err == nil && paymaster != zeroAddress {
+		gasLimits := packUint128s(op.PaymasterVerificationGasLimit, op.PaymasterPostOpGasLimit)
+		packed.PaymasterAndData = append(append(paymaster.Bytes(), gasLimits[:]...), data...)
+	}
+	return packed
+}
+
+// packUint128s packs hi and lo into the two halves of a word.
+func packUint128s(hi, lo *big.Int) [32]byte {
+	var word [32]byte
+	if hi != nil {
+		hi.FillBytes(word[:16])
+	}
+	if lo != nil {
+		lo.FillBytes(word[16:])
+	}
+	return word
+}
+
+var (
+	bytes32Type, _ = abi.NewType("bytes32", "", nil)
+	uint256Type, _ = abi.NewType("uint256", "", nil)
+	addressType, _ = abi.NewType("address", "", nil)
+
+	packedUserOpHashArgs = abi.Arguments{
+		{Type: addressType}, {Type: uint256Type}, {Type: bytes32Type}, {Type: bytes32Type},
+		{Type: bytes32Type}, {Type: uint256Type}, {Type: bytes32Type}, {Type: bytes32Type},
+	}
+	userOpHashArgs = abi.Arguments{{Type: bytes32Type}, {Type: addressType}, {Type: uint256Type}}
+)
+
+// userOpHashV07 returns the hash EntryPoint v0.7 at ep on chainID identifies
+// op by, as its getUserOpHash does.
+func userOpHashV07(op _UserOperation, ep common.Address, chainID uint64) (common.Hash, error) {
+	p := packUserOperationV07(op)
+	inner, err := packedUserOpHashArgs.Pack(p.Sender, p.Nonce, crypto.Keccak256Hash(p.InitCode), crypto.Keccak256Hash(p.CallData),
+		p.AccountGasLimits, p.PreVerificationGas, p.GasFees, crypto.Keccak256Hash(p.PaymasterAndData))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	outer, err := userOpHashArgs.Pack(crypto.Keccak256Hash(inner), ep, new(big.Int).SetUint64(chainID))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(outer), nil
+}
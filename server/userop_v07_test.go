@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestUnmarshalV07UserOperation(t *testing.T) {
+	var op _UserOperation
+	err := json.Unmarshal([]byte(`{
+		"sender": "0x0000000000000000000000000000000000000001",
+		"nonce": 1,
+		"factory": "0x0000000000000000000000000000000000000002",
+		"factoryData": "0xaabb",
+		"callData": "0x",
+		"callGasLimit": 100,
+		"verificationGasLimit": 200,
+		"preVerificationGas": 300,
+		"maxFeePerGas": 10,
+		"maxPriorityFeePerGas": 1,
+		"paymaster": "0x0000000000000000000000000000000000000003",
+		"paymasterVerificationGasLimit": 400,
+		"paymasterPostOpGasLimit": 500,
+		"paymasterData": "0xcc",
+		"signature": "0x"
+	}`), &op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(common.Address{19: 2}.Bytes(), 0xaa, 0xbb); !bytes.Equal(op.InitCode, want) {
+		t.Errorf("initCode = %x, want %x", op.InitCode, want)
+	}
+	if want := append(common.Address{19: 3}.Bytes(), 0xcc); !bytes.Equal(op.PaymasterAndData, want) {
+		t.Errorf("paymasterAndData = %x, want %x", op.PaymasterAndData, want)
+	}
+	if err := checkOpFields(entryPointVersion07, op); err != nil {
+		t.Errorf("v0.7 op rejected for v0.7: %v", err)
+	}
+	if err := checkOpFields(entryPointVersion06, op); err == nil {
+		t.Error("v0.7 op accepted for v0.6")
+	}
+
+	if err := json.Unmarshal([]byte(`{"initCode": "0x01", "factory": "0x0000000000000000000000000000000000000002"}`), &op); err == nil {
+		t.Error("accepted an op with both initCode and factory")
+	}
+}
+
+func TestCheckOpFieldsV07(t *testing.T) {
+	op := _UserOperation{PaymasterAndData: common.Address{19: 3}.Bytes()}
+	if err := checkOpFields(entryPointVersion07, op); err == nil {
+		t.Error("accepted a v0.7 op with a paymaster and no paymaster gas limits")
+	}
+	op = _UserOperation{CallGasLimit: new(big.Int).Lsh(big.NewInt(1), 128)}
+	if err := checkOpFields(entryPointVersion07, op); err == nil {
+		t.Error("accepted a v0.7 op with a gas limit over 128 bits")
+	}
+}
+
+func TestPackUserOperationV07(t *testing.T) {
+	paymaster := common.Address{19: 3}
+	op := _UserOperation{
+		Sender:                        common.Address{19: 1},
+		Nonce:                         big.NewInt(7),
+		InitCode:                      []byte{0x01},
+		CallData:                      []byte{0x02},
+		VerificationGasLimit:          big.NewInt(200),
+		CallGasLimit:                  big.NewInt(100),
+		PreVerificationGas:            big.NewInt(300),
+		MaxPriorityFeePerGas:          big.NewInt(1),
+		MaxFeePerGas:                  big.NewInt(10),
+		PaymasterAndData:              append(paymaster.Bytes(), 0xcc),
+		PaymasterVerificationGasLimit: big.NewInt(400),
+		PaymasterPostOpGasLimit:       big.NewInt(500),
+		Signature:                     []byte{0x03},
+	}
+	packed := packUserOperationV07(op)
+
+	word := func(hi, lo int64) []byte {
+		return append(common.LeftPadBytes(big.NewInt(hi).Bytes(), 16), common.LeftPadBytes(big.NewInt(lo).Bytes(), 16)...)
+	}
+	if !bytes.Equal(packed.AccountGasLimits[:], word(200, 100)) {
+		t.Errorf("accountGasLimits = %x", packed.AccountGasLimits)
+	}
+	if !bytes.Equal(packed.GasFees[:], word(1, 10)) {
+		t.Errorf("gasFees = %x", packed.GasFees)
+	}
+	wantPaymasterAndData := append(append(paymaster.Bytes(), word(400, 500)...), 0xcc)
+	if !bytes.Equal(packed.PaymasterAndData, wantPaymasterAndData) {
+		t.Errorf("paymasterAndData = %x, want %x", packed.PaymasterAndData, wantPaymasterAndData)
+	}
+
+	ep := common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032")
+	hash, err := userOpHashV07(op, ep, 11155111)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// abi.encode of static values is their concatenated words
+	pad := func(b []byte) []byte { return common.LeftPadBytes(b, 32) }
+	inner := bytes.Join([][]byte{
+		pad(op.Sender.Bytes()), pad(op.Nonce.Bytes()),
+		crypto.Keccak256(op.InitCode), crypto.Keccak256(op.CallData),
+		word(200, 100), pad(op.PreVerificationGas.Bytes()), word(1, 10),
+		crypto.Keccak256(wantPaymasterAndData),
+	}, nil)
+	want := crypto.Keccak256Hash(crypto.Keccak256(inner), pad(ep.Bytes()), pad(big.NewInt(11155111).Bytes()))
+	if hash != want {
+		t.Errorf("userOpHash = %v, want %v", hash, want)
+	}
+}
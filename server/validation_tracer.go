@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	e "flashbotsAAbundler/consts"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// simulationGasLimit is the gas given to simulateValidation calls.
+const simulationGasLimit = 30_000_000
+
+// validationTracer is a debug_traceCall JS tracer that records, for every call
+// frame entered during simulateValidation, the opcodes it executed and the
+// storage slots it read and wrote. GAS is only recorded when it is not
+// immediately followed by CALL, CALLCODE, DELEGATECALL or STATICCALL. The preimages of every KECCAK256
+// are recorded too, to find the slots of mappings keyed by an address.
+const validationTracer = `{
+	frames: [],
+	open: [],
+	keccak: [],
+	callOps: {CALL: true, CALLCODE: true, DELEGATECALL: true, STATICCALL: true},
+	step: function(log, db) {
+		var depth = log.getDepth();
+		while (this.open.length > 0 && this.frames[this.open[this.open.length - 1]].depth > depth) {
+			this.open.pop();
+		}
+		var top = this.open.length > 0 ? this.open[this.open.length - 1] : -1;
+		if (top < 0 || this.frames[top].depth < depth) {
+			this.frames.push({address: toHex(log.contract.getAddress()), depth: depth, parent: top, opcodes: {}, reads: {}, writes: {}, lastOp: ""});
+			top = this.frames.length - 1;
+			this.open.push(top);
+		}
+		var frame = this.frames[top];
+		var op = log.op.toString();
+		if (frame.lastOp == "GAS" && !this.callOps[op]) {
+			frame.opcodes["GAS"] = (frame.opcodes["GAS"] || 0) + 1;
+		}
+		if (op != "GAS") {
+			frame.opcodes[op] = (frame.opcodes[op] || 0) + 1;
+		}
+		if (op == "SLOAD" || op == "SSTORE") {
+			var slot = toHex(toWord("0x" + log.stack.peek(0).toString(16)));
+			var access = op == "SLOAD" ? frame.reads : frame.writes;
+			access[slot] = (access[slot] || 0) + 1;
+		}
+		if (op == "SHA3" || op == "KECCAK256") {
+			var offset = log.stack.peek(0).valueOf();
+			var size = log.stack.peek(1).valueOf();
+			if (size > 0 && size <= 512 && offset + size <= log.memory.length()) {
+				this.keccak.push(toHex(log.memory.slice(offset, offset + size)));
+			}
+		}
+		frame.lastOp = op;
+	},
+	fault: function(log, db) {},
+	result: function(ctx, db) {
+		for (var i = 0; i < this.frames.length; i++) {
+			delete this.frames[i].lastOp;
+		}
+		return {frames: this.frames, keccak: this.keccak};
+	}
+}`
+
+// validationTrace is the output of validationTracer.
+type validationTrace struct {
+	Frames []traceFrame    `json:"frames"`
+	Keccak []hexutil.Bytes `json:"keccak"` // KECCAK256 preimages
+}
+
+type traceFrame struct {
+	// Address is the contract whose storage the frame accesses, i.e. the
+	// caller for DELEGATECALL frames.
+	Address common.Address      `json:"address"`
+	Depth   int                 `json:"depth"`
+	Parent  int                 `json:"parent"` // index in Frames, -1 for the EntryPoint
+	Opcodes map[string]int      `json:"opcodes"`
+	Reads   map[common.Hash]int `json:"reads"`
+	Writes  map[common.Hash]int `json:"writes"`
+}
+
+const (
+	entityAccount    = "account"
+	entityPaymaster  = "paymaster"
+	entityFactory    = "factory"
+	entityAggregator = "aggregator"
+)
+
+// bannedOpcodes may not be used by any entity during validation (ERC-7562).
+// Names of both older and newer node versions are listed.
+var bannedOpcodes = map[string]bool{
+	"GASPRICE":     true,
+	"GASLIMIT":     true,
+	"DIFFICULTY":   true,
+	"PREVRANDAO":   true,
+	"TIMESTAMP":    true,
+	"BASEFEE":      true,
+	"BLOCKHASH":    true,
+	"NUMBER":       true,
+	"SELFBALANCE":  true,
+	"BALANCE":      true,
+	"ORIGIN":       true,
+	"GAS":          true,
+	"CREATE":       true,
+	"COINBASE":     true,
+	"SELFDESTRUCT": true,
+	"BLOBHASH":     true,
+	"BLOBBASEFEE":  true,
+}
+
+// traceValidation runs simulateValidation for s through debug_traceCall with
+// validationTracer.
+func (s _UserOperation) traceValidation(client *rpc.Client, ep common.Address, version entryPointVersion) (*validationTrace, error) {
+	data, err := packSimulateValidation(version, s)
+	if err != nil {
+		return nil, err
+	}
+	args := map[string]interface{}{
+		"from": zeroAddress,
+		"to":   ep,
+		"gas":  hexutil.Uint64(simulationGasLimit),
+		"data": hexutil.Bytes(data),
+	}
+	var trace validationTrace
+	err = client.CallContext(context.Background(), &trace, "debug_traceCall", args, "latest", map[string]interface{}{
+		"tracer": validationTracer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &trace, nil
+}
+
+// packSimulateValidation returns the simulateValidation call of an EntryPoint
+// of version for s.
+func packSimulateValidation(version entryPointVersion, s _UserOperation) ([]byte, error) {
+	if version == entryPointVersion07 {
+		return nil, errV07Simulation
+	}
+	epABI, err := EntryPointMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return epABI.Pack("simulateValidation", buildUserOperationArray(s)[0])
+}
+
+// entityOf attributes frame i to the outermost entity in its call path.
+func (t *validationTrace) entityOf(i int, entities validationEntities) string {
+	var path []int
+	for ; i >= 0; i = t.Frames[i].Parent {
+		path = append(path, i)
+	}
+	for j := len(path) - 1; j >= 0; j-- {
+		f := t.Frames[path[j]]
+		switch {
+		case f.Parent < 0:
+			continue // the EntryPoint itself
+		case f.Address == entities.Sender:
+			return entityAccount
+		case f.Address == entities.Paymaster && entities.Paymaster != zeroAddress:
+			return entityPaymaster
+		case f.Address == entities.Factory && entities.Factory != zeroAddress:
+			return entityFactory
+		}
+	}
+	return ""
+}
+
+// checkOpcodeRules rejects traces in which an entity used a banned opcode, or
+// in which CREATE2 was used other than once by the factory.
+func checkOpcodeRules(t *validationTrace, entities validationEntities) *RPCError {
+	create2 := 0
+	for i, f := range t.Frames {
+		entity := t.entityOf(i, entities)
+		if entity == "" {
+			continue
+		}
+		ops := make([]string, 0, len(f.Opcodes))
+		for op := range f.Opcodes {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			banned := bannedOpcodes[op]
+			if op == "CREATE2" {
+				create2 += f.Opcodes[op]
+				banned = entity != entityFactory || !entities.HasInitCode || create2 > 1
+			}
+			if banned {
+				return &RPCError{
+					Code:    e.JsonRpcBannedOpcode,
+					Message: fmt.Sprintf("%s %s uses banned opcode %s during validation", entity, f.Address, op),
+					Data: map[string]interface{}{
+						"entity":   entity,
+						"contract": f.Address,
+						"opcode":   op,
+					},
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	_ "github.com/ethereum/go-ethereum/eth/tracers/js"
+)
+
+// runValidationTracer executes code with validationTracer attached, the way
+// debug_traceCall would, and returns the decoded trace.
+func runValidationTracer(t *testing.T, sdb *state.StateDB, code []byte) *validationTrace {
+	tracer, err := tracers.New(validationTracer, new(tracers.Context), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &runtime.Config{
+		State:       sdb,
+		EVMConfig:   vm.Config{Debug: true, Tracer: tracer},
+		GasLimit:    1e6,
+		BlockNumber: big.NewInt(1),
+	}
+	if _, _, err := runtime.Execute(code, nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace validationTrace
+	if err := json.Unmarshal(res, &trace); err != nil {
+		t.Fatal(err)
+	}
+	return &trace
+}
+
+func TestBannedOpcodes(t *testing.T) {
+	sdb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	account := common.HexToAddress("0xaa")
+	sdb.SetCode(account, []byte{byte(vm.TIMESTAMP), byte(vm.POP), byte(vm.STOP)})
+	// the "EntryPoint" calls the account with GAS CALL, which is allowed
+	entryPoint := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0xaa, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP),
+	}
+
+	trace := runValidationTracer(t, sdb, entryPoint)
+	if len(trace.Frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(trace.Frames))
+	}
+	if trace.Frames[0].Opcodes["GAS"] != 0 {
+		t.Errorf("GAS followed by CALL should not be recorded")
+	}
+	rpcErr := checkOpcodeRules(trace, validationEntities{Sender: account})
+	if rpcErr == nil {
+		t.Fatal("expected TIMESTAMP to be rejected")
+	}
+	if data := rpcErr.Data.(map[string]interface{}); data["opcode"] != "TIMESTAMP" || data["entity"] != entityAccount {
+		t.Errorf("unexpected error data %v", data)
+	}
+}
+
+func TestGasFollowedByCallData(t *testing.T) {
+	sdb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	account := common.HexToAddress("0xaa")
+	// CALLDATALOAD has CALL in its name but is not a call
+	sdb.SetCode(account, []byte{byte(vm.GAS), byte(vm.CALLDATALOAD), byte(vm.POP), byte(vm.STOP)})
+	entryPoint := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0xaa, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP),
+	}
+
+	trace := runValidationTracer(t, sdb, entryPoint)
+	if len(trace.Frames) != 2 || trace.Frames[1].Opcodes["GAS"] != 1 {
+		t.Fatalf("GAS followed by CALLDATALOAD was not recorded: %+v", trace.Frames)
+	}
+	rpcErr := checkOpcodeRules(trace, validationEntities{Sender: account})
+	if rpcErr == nil {
+		t.Fatal("expected GAS followed by CALLDATALOAD to be rejected")
+	}
+	if data := rpcErr.Data.(map[string]interface{}); data["opcode"] != "GAS" {
+		t.Errorf("unexpected error data %v", data)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"math/big"
+
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -14,3 +16,48 @@ func (r *Request) WriteRPCResponse(success bool, txHash common.Hash) (res *Respo
 		},
 	}
 }
+
+// RPCError is a JSON-RPC error object. It is returned by validation steps
+// that reject a user operation with an ERC-4337 error code.
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+type ErrorResponse struct {
+	Jsonrpc string    `json:"jsonrpc"`
+	Id      *big.Int  `json:"id"`
+	Error   *RPCError `json:"error"`
+}
+
+func (r *Request) WriteRPCError(rpcErr *RPCError) (res *ErrorResponse) {
+	return NewRPCError(r.Id, rpcErr)
+}
+
+func NewRPCError(id *big.Int, rpcErr *RPCError) *ErrorResponse {
+	return &ErrorResponse{
+		Jsonrpc: "2.0",
+		Id:      id,
+		Error:   rpcErr,
+	}
+}
+
+// ResultResponse is a JSON-RPC response with an arbitrary result.
+type ResultResponse struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Id      *big.Int    `json:"id"`
+	Result  interface{} `json:"result"`
+}
+
+func NewRPCResult(id *big.Int, result interface{}) *ResultResponse {
+	return &ResultResponse{
+		Jsonrpc: "2.0",
+		Id:      id,
+		Result:  result,
+	}
+}